@@ -5,6 +5,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof" // Used for debugging if enabled and a web server is running
@@ -13,6 +14,7 @@ import (
 
 	"code.gitea.io/gitea/modules/graceful"
 	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/process"
 	"code.gitea.io/gitea/modules/setting"
 	"code.gitea.io/gitea/routers"
 	"code.gitea.io/gitea/routers/routes"
@@ -107,6 +109,14 @@ func runWeb(ctx *cli.Context) error {
 		setting.CustomPID = ctx.String("pid")
 	}
 
+	// Let the commands still running at shutdown finish, within reason
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-graceful.Manager.IsShutdown()
+		cancel()
+	}()
+	process.SetShutdownContext(shutdownCtx)
+
 	// Perform global initialization
 	routers.GlobalInit()
 
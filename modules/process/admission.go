@@ -0,0 +1,75 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// AdmissionFunc decides whether a new command may be started. A non-nil error
+// rejects the command, which then fails with that error without being forked.
+type AdmissionFunc func() error
+
+// SetAdmissionFunc sets a check run before every command started by the manager,
+// e.g. to refuse forking while the host is short of memory. nil admits everything.
+// It does not apply to processes added with Add or Register.
+func (pm *Manager) SetAdmissionFunc(fn AdmissionFunc) {
+	pm.mutex.Lock()
+	pm.admission = fn
+	pm.mutex.Unlock()
+}
+
+// LoadAverageAdmission returns an AdmissionFunc rejecting commands with ErrSystemOverloaded
+// while the 1-minute load average of the host exceeds max, so that an overloaded host is
+// not made worse by forking more git commands. The load average is logged along with
+// each rejection. It admits everything where the load average can't be read, i.e. on
+// other systems than Linux.
+func LoadAverageAdmission(max float64) AdmissionFunc {
+	return func() error {
+		load, err := readLoadAverage()
+		if err == nil && load > max {
+			log.Warn("Process not started as the load average %.2f is above %.2f", load, max)
+			return ErrSystemOverloaded
+		}
+		return nil
+	}
+}
+
+func (pm *Manager) admit() error {
+	pm.mutex.Lock()
+	closed, draining, admission := pm.closed, pm.draining, pm.admission
+	pm.mutex.Unlock()
+
+	if closed {
+		return ErrClosed
+	}
+	if draining {
+		return ErrDraining
+	}
+	if admission != nil {
+		return admission()
+	}
+	return nil
+}
+
+// Drain stops admitting commands, which fail with ErrDraining, and waits until no
+// process is tracked or ctx is done, e.g. to reload the git configuration without
+// killing anything. The manager stays drained until ResumeAdmission, even if ctx is
+// done first. Like WaitIdle, it also waits for the processes added with Add or Register.
+func (pm *Manager) Drain(ctx context.Context) error {
+	pm.mutex.Lock()
+	pm.draining = true
+	pm.mutex.Unlock()
+	return pm.WaitIdle(ctx)
+}
+
+// ResumeAdmission admits commands again after Drain.
+func (pm *Manager) ResumeAdmission() {
+	pm.mutex.Lock()
+	pm.draining = false
+	pm.mutex.Unlock()
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_SetAdmissionFunc(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	errLowMemory := errors.New("low memory")
+	var started bool
+	pm.SetAdmissionFunc(func() error {
+		return errLowMemory
+	})
+	_, _, err := pm.ExecWithOptions("Rejected", "echo", nil, WithAfterStart(func(*Process) {
+		started = true
+	}))
+	assert.Equal(t, errLowMemory, err)
+	assert.False(t, started, "the process must not be started")
+	assert.Empty(t, pm.History(), "the process must never be added")
+
+	pm.SetAdmissionFunc(nil)
+	_, _, err = pm.Exec("Admitted", "echo")
+	assert.NoError(t, err)
+	assert.Len(t, pm.History(), 1)
+}
+
+func TestManager_Drain(t *testing.T) {
+	pm := newFakeManager()
+
+	h, err := pm.Start("Current", "hang", []string{"1000"})
+	assert.NoError(t, err)
+	drained := make(chan error, 1)
+	go func() {
+		drained <- pm.Drain(context.Background())
+	}()
+	eventually(t, func() bool {
+		pm.mutex.Lock()
+		defer pm.mutex.Unlock()
+		return pm.draining
+	}, time.Second, 10*time.Millisecond)
+	_, _, err = pm.Exec("Rejected", "echo")
+	assert.Equal(t, ErrDraining, err, "new commands must be rejected")
+	select {
+	case <-drained:
+		t.Fatal("Drain returned while a command was running")
+	default:
+	}
+
+	assert.NoError(t, <-drained)
+	_, _, err = h.Wait()
+	assert.NoError(t, err, "the current command must finish")
+	_, _, err = pm.Exec("Rejected", "echo")
+	assert.Equal(t, ErrDraining, err, "the manager stays drained until resumed")
+
+	pm.ResumeAdmission()
+	stdout, _, err := pm.Exec("Admitted", "echo", "again")
+	assert.NoError(t, err)
+	assert.Equal(t, "again", stdout)
+
+	pid := pm.Add("Stuck", nil)
+	defer pm.Remove(pid)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pm.Drain(ctx))
+	pm.ResumeAdmission()
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os"
+	"strings"
+)
+
+// ArgRedactor returns a copy of the command line of a process with its secrets, e.g. the
+// credentials of a remote URL, masked. It must not modify argv.
+type ArgRedactor func(argv []string) []string
+
+// SetArgRedactor sets the function redacting the command lines recorded in Process.Argv,
+// the history and the audit hook. A nil redactor records them as they are.
+func (pm *Manager) SetArgRedactor(fn ArgRedactor) {
+	pm.mutex.Lock()
+	pm.redactor = fn
+	pm.mutex.Unlock()
+}
+
+// AuditFunc is notified of every process once it is tracked.
+type AuditFunc func(info ProcessInfo)
+
+// SetAuditHook sets a function called synchronously with every process added to the
+// manager, including its redacted command line and the privilege-relevant options it
+// was run with, e.g. to keep an audit trail of what was executed and how it was
+// sandboxed. It must not block. A nil hook disables it.
+func (pm *Manager) SetAuditHook(fn AuditFunc) {
+	pm.mutex.Lock()
+	pm.audit = fn
+	pm.mutex.Unlock()
+}
+
+// Privileges are the privilege-relevant options of a command run by the manager, as
+// reported by ProcessInfo.
+type Privileges uint8
+
+// The privilege-relevant options.
+const (
+	PrivilegeCredential Privileges = 1 << iota // WithCredential
+	PrivilegeChroot                            // WithChroot
+	PrivilegeCleanEnv                          // WithCleanEnv, even if variables are then added
+)
+
+var privilegeNames = []struct {
+	privilege Privileges
+	name      string
+}{
+	{PrivilegeCredential, "credential"},
+	{PrivilegeChroot, "chroot"},
+	{PrivilegeCleanEnv, "clean env"},
+}
+
+func (p Privileges) String() string {
+	var names []string
+	for _, n := range privilegeNames {
+		if p&n.privilege != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// privileges returns the privilege-relevant options set in o.
+func (o *runOptions) privileges() Privileges {
+	var p Privileges
+	if o.credential != nil {
+		p |= PrivilegeCredential
+	}
+	if o.chroot != "" {
+		p |= PrivilegeChroot
+	}
+	if o.cleanEnv {
+		p |= PrivilegeCleanEnv
+	}
+	return p
+}
+
+// redactArgv returns a copy of argv redacted by the redactor of the manager, if any.
+func (pm *Manager) redactArgv(argv []string) []string {
+	if argv == nil {
+		return nil
+	}
+	pm.mutex.Lock()
+	redactor := pm.redactor
+	pm.mutex.Unlock()
+
+	if redactor != nil {
+		return redactor(argv)
+	}
+	return append([]string(nil), argv...)
+}
+
+// EnvRedactor returns a copy of an environment, in the KEY=value format, with the values
+// of its secrets masked. It must not modify env.
+type EnvRedactor func(env []string) []string
+
+// sensitiveEnvNames are the parts of the names of the variables masked by RedactSensitiveEnv.
+var sensitiveEnvNames = []string{"TOKEN", "PASSWORD", "PASSWD", "SECRET", "CREDENTIAL", "KEY"}
+
+// RedactSensitiveEnv is the default EnvRedactor: it masks the values of the variables
+// whose name contains TOKEN, PASSWORD, PASSWD, SECRET, CREDENTIAL or KEY, in any case.
+func RedactSensitiveEnv(env []string) []string {
+	redacted := make([]string, len(env))
+	for i, kv := range env {
+		redacted[i] = kv
+		eq := strings.IndexByte(kv, '=')
+		if eq < 0 {
+			continue
+		}
+		name := strings.ToUpper(kv[:eq])
+		for _, sensitive := range sensitiveEnvNames {
+			if strings.Contains(name, sensitive) {
+				redacted[i] = kv[:eq+1] + "******"
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// SetEnvRedactor sets the function redacting the environments recorded by WithRecordEnv.
+// A nil redactor means RedactSensitiveEnv.
+func (pm *Manager) SetEnvRedactor(fn EnvRedactor) {
+	pm.mutex.Lock()
+	pm.envRedactor = fn
+	pm.mutex.Unlock()
+}
+
+// WithRecordEnv records the environment the command is started with in Process.Env, as
+// redacted by SetEnvRedactor, e.g. to see what a misbehaving hook was given from the
+// process list. It is off by default to spare a copy of the environment per process.
+func WithRecordEnv() RunOption {
+	return func(o *runOptions) {
+		o.recordEnv = true
+	}
+}
+
+// redactEnv returns a copy of the environment of cmd redacted by the env redactor of the
+// manager.
+func (pm *Manager) redactEnv(env []string) []string {
+	if env == nil {
+		// Like exec.Cmd
+		env = os.Environ()
+	}
+	pm.mutex.Lock()
+	redactor := pm.envRedactor
+	pm.mutex.Unlock()
+
+	if redactor == nil {
+		redactor = RedactSensitiveEnv
+	}
+	return redactor(env)
+}
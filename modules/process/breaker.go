@@ -0,0 +1,96 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker per category and normalized description of commands.
+type breaker struct {
+	mutex    sync.Mutex
+	failures int
+	cooldown time.Duration
+	circuits map[string]*circuit
+}
+
+// circuit is the state of the commands sharing a key. It is open while openedAt is set.
+type circuit struct {
+	failures int // consecutive
+	openedAt time.Time
+	probedAt time.Time // when the last command was let through once the cooldown had passed
+}
+
+// SetCircuitBreaker makes the manager refuse, with ErrCircuitOpen, to start a command for
+// cooldown once failures commands with the same category and description have failed in
+// a row, e.g. syncs of a mirror whose remote is gone. Descriptions are compared once
+// numbers and commit IDs are normalized away. After the cooldown, a single command is let
+// through: the circuit closes again if it succeeds and stays open for another cooldown
+// otherwise. Commands canceled by their context or killed through the manager don't count.
+// 0 disables the breaker, which is the default. It does not apply to processes added
+// with Add or Register.
+func (pm *Manager) SetCircuitBreaker(failures int, cooldown time.Duration) {
+	b := &pm.breaker
+	b.mutex.Lock()
+	b.failures = failures
+	b.cooldown = cooldown
+	b.circuits = nil
+	b.mutex.Unlock()
+}
+
+func circuitKey(c Category, desc string) string {
+	return strconv.Itoa(int(c)) + ":" + normalizeDescription(desc)
+}
+
+// allow returns the key of a command about to be started at now, or ErrCircuitOpen.
+// The key is empty if the breaker is disabled.
+func (b *breaker) allow(c Category, desc string, now time.Time) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures <= 0 {
+		return "", nil
+	}
+	key := circuitKey(c, desc)
+	cc := b.circuits[key]
+	if cc == nil || cc.openedAt.IsZero() {
+		return key, nil
+	}
+	// A command is let through once per cooldown, in case the previous one never reported.
+	if now.Sub(cc.openedAt) < b.cooldown || now.Sub(cc.probedAt) < b.cooldown {
+		return "", ErrCircuitOpen
+	}
+	cc.probedAt = now
+	return key, nil
+}
+
+// record reports whether the command allowed with key failed at now.
+func (b *breaker) record(key string, failed bool, now time.Time) {
+	if key == "" {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures <= 0 {
+		return
+	}
+	if !failed {
+		delete(b.circuits, key)
+		return
+	}
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	cc := b.circuits[key]
+	if cc == nil {
+		cc = &circuit{}
+		b.circuits[key] = cc
+	}
+	cc.failures++
+	if !cc.openedAt.IsZero() || cc.failures >= b.failures {
+		cc.openedAt = now
+	}
+}
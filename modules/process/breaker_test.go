@@ -0,0 +1,101 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{failures: 2, cooldown: time.Minute}
+	now := time.Date(2019, 11, 25, 12, 0, 0, 0, time.UTC)
+
+	fail := func() {
+		key, err := b.allow(CategoryMirror, "mirror sync 42", now)
+		assert.NoError(t, err)
+		b.record(key, true, now)
+	}
+	fail()
+	key, err := b.allow(CategoryMirror, "mirror sync 42", now)
+	assert.NoError(t, err, "a success resets the failures")
+	b.record(key, false, now)
+
+	fail()
+	fail()
+	_, err = b.allow(CategoryMirror, "mirror sync 43", now)
+	assert.Equal(t, ErrCircuitOpen, err, "the circuit is open, numbers being normalized away")
+	_, err = b.allow(CategoryGit, "mirror sync 42", now)
+	assert.NoError(t, err, "other categories are not affected")
+
+	// Once the cooldown has passed, a single command tests whether it still fails
+	now = now.Add(time.Minute)
+	probe, err := b.allow(CategoryMirror, "mirror sync 42", now)
+	assert.NoError(t, err)
+	_, err = b.allow(CategoryMirror, "mirror sync 42", now)
+	assert.Equal(t, ErrCircuitOpen, err, "only one command is let through")
+	b.record(probe, true, now)
+	now = now.Add(30 * time.Second)
+	_, err = b.allow(CategoryMirror, "mirror sync 42", now)
+	assert.Equal(t, ErrCircuitOpen, err, "a failed probe opens the circuit for another cooldown")
+
+	now = now.Add(30 * time.Second)
+	probe, err = b.allow(CategoryMirror, "mirror sync 42", now)
+	assert.NoError(t, err)
+	b.record(probe, false, now)
+	for i := 0; i < 3; i++ {
+		key, err := b.allow(CategoryMirror, "mirror sync 42", now)
+		assert.NoError(t, err, "the circuit must be closed once recovered")
+		b.record(key, false, now)
+	}
+}
+
+func TestManager_SetCircuitBreaker(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+	pm.SetCircuitBreaker(2, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := pm.ExecWithOptions("Sync", "fail", []string{"1", "remote gone"}, WithCategory(CategoryMirror))
+		assert.Error(t, err)
+		assert.NotEqual(t, ErrCircuitOpen, err)
+	}
+	_, _, err := pm.ExecWithOptions("Sync", "echo", nil, WithCategory(CategoryMirror))
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Len(t, pm.History(), 2, "the command must not be started")
+
+	_, _, err = pm.Exec("Other", "echo")
+	assert.NoError(t, err)
+
+	pm.SetCircuitBreaker(0, 0)
+	_, _, err = pm.ExecWithOptions("Sync", "echo", nil, WithCategory(CategoryMirror))
+	assert.NoError(t, err)
+}
+
+func TestManager_SetCircuitBreakerQueued(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetCircuitBreaker(1, 200*time.Millisecond)
+	_, _, err := pm.ExecWithOptions("Sync", "fail", []string{"1", "remote gone"}, WithCategory(CategoryMirror))
+	assert.Error(t, err)
+
+	pm.SetMaxConcurrent(1)
+	h, err := pm.Start("Busy", "hang", nil)
+	assert.NoError(t, err)
+	time.Sleep(250 * time.Millisecond)
+
+	// A command giving up while waiting for a slot must not use up the probe
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = pm.ExecWithOptions("Sync", "echo", nil, WithCategory(CategoryMirror), WithContext(ctx))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.NoError(t, pm.Kill(h.PID()))
+	_, _, _ = h.Wait()
+	_, _, err = pm.ExecWithOptions("Sync", "echo", nil, WithCategory(CategoryMirror))
+	assert.NoError(t, err, "the probe must be let through")
+}
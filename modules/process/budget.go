@@ -0,0 +1,107 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// OutputTruncatedMarker is appended to the captured output of a process once
+// the manager output budget is exhausted. Any further output is dropped.
+const OutputTruncatedMarker = "\n[output truncated: process manager output budget exceeded]\n"
+
+// SetOutputBudget limits the number of output bytes buffered across all running
+// processes of the manager. Budget used by a process is released when it is removed.
+// A budget of 0 or less disables the limit. Processes started while the limit was
+// disabled aren't limited, so that they don't pay for the budget accounting.
+func (pm *Manager) SetOutputBudget(bytes int64) {
+	atomic.StoreInt64(&pm.outputBudget, bytes)
+}
+
+// reserveOutput takes up to n bytes from the output budget and returns how many were granted.
+func (pm *Manager) reserveOutput(n int64) int64 {
+	for {
+		budget := atomic.LoadInt64(&pm.outputBudget)
+		if budget <= 0 {
+			return n
+		}
+		used := atomic.LoadInt64(&pm.outputUsed)
+		granted := budget - used
+		if granted <= 0 {
+			return 0
+		}
+		if granted > n {
+			granted = n
+		}
+		if atomic.CompareAndSwapInt64(&pm.outputUsed, used, used+granted) {
+			return granted
+		}
+	}
+}
+
+// outputBudget tracks the budget charged by the captured outputs of a single process.
+type outputBudget struct {
+	pm *Manager
+
+	mutex    sync.Mutex
+	reserved int64
+	capped   bool
+}
+
+func (b *outputBudget) writer(w io.Writer) io.Writer {
+	return &budgetWriter{budget: b, w: w}
+}
+
+// release gives the budget charged by the process back to the manager.
+// It must only be called once the process outputs are fully written.
+func (b *outputBudget) release() {
+	b.mutex.Lock()
+	if b.reserved > 0 {
+		atomic.AddInt64(&b.pm.outputUsed, -b.reserved)
+		b.reserved = 0
+	}
+	b.mutex.Unlock()
+}
+
+type budgetWriter struct {
+	budget *outputBudget
+	w      io.Writer
+	capped bool
+}
+
+// Write writes as much of p as the budget allows and reports the whole of p as
+// written, so that the command isn't failed because its output is dropped.
+func (bw *budgetWriter) Write(p []byte) (int, error) {
+	if bw.capped {
+		return len(p), nil
+	}
+	b := bw.budget
+	granted := b.pm.reserveOutput(int64(len(p)))
+
+	b.mutex.Lock()
+	b.reserved += granted
+	first := false
+	if granted < int64(len(p)) && !b.capped {
+		b.capped = true
+		first = true
+	}
+	b.mutex.Unlock()
+
+	if _, err := bw.w.Write(p[:granted]); err != nil {
+		return 0, err
+	}
+	if granted < int64(len(p)) {
+		bw.capped = true
+		if first {
+			atomic.AddInt64(&b.pm.stats.outputCapped, 1)
+		}
+		if _, err := io.WriteString(bw.w, OutputTruncatedMarker); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
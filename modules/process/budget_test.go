@@ -0,0 +1,51 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_SetOutputBudget(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetOutputBudget(1000)
+
+	// Each process keeps its 500 bytes buffered for a second, so only two fit in the budget.
+	var handles []*Handle
+	for i := 0; i < 5; i++ {
+		h, err := pm.Start("Spew", "spew", []string{"500", "1000"})
+		assert.NoError(t, err)
+		handles = append(handles, h)
+	}
+
+	total, truncated := 0, 0
+	for _, h := range handles {
+		stdout, _, err := h.Wait()
+		assert.NoError(t, err)
+		if strings.HasSuffix(stdout, OutputTruncatedMarker) {
+			truncated++
+			stdout = strings.TrimSuffix(stdout, OutputTruncatedMarker)
+		}
+		total += len(stdout)
+	}
+	assert.Equal(t, 1000, total)
+	assert.Equal(t, 3, truncated)
+	assert.Equal(t, int64(3), pm.Stats().OutputCapped)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&pm.outputUsed), "budget was not released")
+
+	// The released budget is available again
+	stdout, _, err := pm.Exec("Spew", "spew", "800", "0")
+	assert.NoError(t, err)
+	assert.Len(t, stdout, 800)
+
+	pm.SetOutputBudget(0)
+	stdout, _, err = pm.Exec("Spew", "spew", "5000", "0")
+	assert.NoError(t, err)
+	assert.Len(t, stdout, 5000)
+}
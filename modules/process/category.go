@@ -0,0 +1,199 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Category classifies processes for aggregation, descriptions being free-form.
+type Category int
+
+// The categories of processes.
+const (
+	CategoryUnknown Category = iota // processes without a category
+	CategoryGit                     // user-facing git commands
+	CategoryHook                    // git hooks
+	CategoryLFS                     // LFS transfers
+	CategoryMirror                  // background mirror updates
+)
+
+var categoryNames = map[Category]string{
+	CategoryUnknown: "unknown",
+	CategoryGit:     "git",
+	CategoryHook:    "hook",
+	CategoryLFS:     "lfs",
+	CategoryMirror:  "mirror",
+}
+
+func (c Category) String() string {
+	if name, ok := categoryNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// DefaultKillOrder is the order in which KillAll kills the categories of processes by
+// default: background work first and user-facing git commands last, so that the requests
+// in flight have a better chance to complete during a shutdown.
+var DefaultKillOrder = []Category{CategoryMirror, CategoryUnknown, CategoryLFS, CategoryHook, CategoryGit}
+
+// WithCategory sets the category of the command.
+func WithCategory(c Category) RunOption {
+	return func(o *runOptions) {
+		o.category = c
+	}
+}
+
+// CountByCategory returns the number of processes currently tracked in each category.
+// Categories without processes are absent.
+func (pm *Manager) CountByCategory() map[Category]int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	counts := make(map[Category]int)
+	for _, proc := range pm.Processes {
+		counts[proc.Category]++
+	}
+	return counts
+}
+
+// ByCategory returns a snapshot of the processes in a category, ordered by PID.
+func (pm *Manager) ByCategory(c Category) []*Process {
+	pm.mutex.Lock()
+	var procs []*Process
+	for _, proc := range pm.Processes {
+		if proc.Category == c {
+			procs = append(procs, proc.snapshot())
+		}
+	}
+	pm.mutex.Unlock()
+
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].PID < procs[j].PID
+	})
+	return procs
+}
+
+// SetDefaultTimeout sets the timeout of the commands of a category given -1 as timeout,
+// e.g. to cap interactive git commands lower than background ones. A duration of 0
+// restores DefaultTimeout, which applies to the categories without a default.
+func (pm *Manager) SetDefaultTimeout(c Category, d time.Duration) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	if d == 0 {
+		delete(pm.defaultTimeouts, c)
+		return
+	}
+	if pm.defaultTimeouts == nil {
+		pm.defaultTimeouts = make(map[Category]time.Duration)
+	}
+	pm.defaultTimeouts[c] = d
+}
+
+func (pm *Manager) defaultTimeout(c Category) time.Duration {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	if d, ok := pm.defaultTimeouts[c]; ok {
+		return d
+	}
+	return DefaultTimeout
+}
+
+// KillOlderThanByCategory terminates the processes of a category which have been running
+// for longer than age, e.g. stuck mirror updates, leaving the other categories alone.
+// The processes are terminated concurrently as by Terminate, with DefaultTerminateGrace.
+// It returns the PIDs of the processes terminated and the errors of those which couldn't
+// be. Processes which exit or haven't been started meanwhile are skipped.
+func (pm *Manager) KillOlderThanByCategory(age time.Duration, c Category) ([]int64, []error) {
+	var candidates []int64
+	for _, proc := range pm.OlderThan(age) {
+		if proc.Category == c {
+			candidates = append(candidates, proc.PID)
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mutex  sync.Mutex
+		killed []int64
+		errs   []error
+	)
+	for _, pid := range candidates {
+		wg.Add(1)
+		go func(pid int64) {
+			defer wg.Done()
+			err := pm.Terminate(pid, DefaultTerminateGrace)
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch err {
+			case nil:
+				killed = append(killed, pid)
+			case ErrNotFound, ErrNotStarted:
+			default:
+				errs = append(errs, err)
+			}
+		}(pid)
+	}
+	wg.Wait()
+
+	sort.Slice(killed, func(i, j int) bool {
+		return killed[i] < killed[j]
+	})
+	return killed, errs
+}
+
+// SetKillOrder sets the order in which KillAll, and so Close, kills the categories of
+// processes. The categories missing from order are killed after the others. No order
+// means DefaultKillOrder.
+func (pm *Manager) SetKillOrder(order ...Category) {
+	pm.mutex.Lock()
+	pm.killOrder = append([]Category(nil), order...)
+	pm.mutex.Unlock()
+}
+
+// killTiersLocked returns the PIDs of the processes in the order KillAll kills them,
+// grouped by category rank: each tier is killed and waited for before the next one.
+// It must be called with the mutex held.
+func (pm *Manager) killTiersLocked() [][]int64 {
+	order := pm.killOrder
+	if len(order) == 0 {
+		order = DefaultKillOrder
+	}
+	rank := make(map[Category]int, len(order))
+	for i, c := range order {
+		if _, ok := rank[c]; !ok {
+			rank[c] = i
+		}
+	}
+	rankOf := func(c Category) int {
+		if r, ok := rank[c]; ok {
+			return r
+		}
+		return len(order)
+	}
+
+	pids := make([]int64, 0, len(pm.Processes))
+	for pid := range pm.Processes {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		ri, rj := rankOf(pm.Processes[pids[i]].Category), rankOf(pm.Processes[pids[j]].Category)
+		if ri != rj {
+			return ri < rj
+		}
+		return pids[i] < pids[j]
+	})
+
+	var tiers [][]int64
+	for i, pid := range pids {
+		if i == 0 || rankOf(pm.Processes[pid].Category) != rankOf(pm.Processes[pids[i-1]].Category) {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], pid)
+	}
+	return tiers
+}
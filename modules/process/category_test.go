@@ -0,0 +1,233 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_CountByCategory(t *testing.T) {
+	pm := newFakeManager()
+
+	var handles []*Handle
+	for _, c := range []Category{CategoryGit, CategoryGit, CategoryMirror, CategoryUnknown} {
+		h, err := pm.Start("Hang", "hang", nil, WithCategory(c))
+		assert.NoError(t, err)
+		handles = append(handles, h)
+	}
+	defer func() {
+		for _, h := range handles {
+			_ = pm.Kill(h.PID())
+			<-h.Done()
+		}
+	}()
+
+	assert.Equal(t, map[Category]int{
+		CategoryGit:     2,
+		CategoryMirror:  1,
+		CategoryUnknown: 1,
+	}, pm.CountByCategory())
+
+	git := pm.ByCategory(CategoryGit)
+	if assert.Len(t, git, 2) {
+		assert.Equal(t, handles[0].PID(), git[0].PID)
+		assert.Equal(t, handles[1].PID(), git[1].PID)
+		assert.Equal(t, CategoryGit, git[0].Category)
+	}
+	assert.Len(t, pm.ByCategory(CategoryMirror), 1)
+	assert.Empty(t, pm.ByCategory(CategoryLFS))
+
+	assert.Equal(t, "mirror", CategoryMirror.String())
+	assert.Equal(t, "unknown", Category(42).String())
+}
+
+func TestManager_KillOlderThanByCategory(t *testing.T) {
+	pm := newFakeManager()
+
+	start := func(c Category, age time.Duration) *Handle {
+		h, err := pm.Start("Hang", "hang", nil, WithCategory(c))
+		assert.NoError(t, err)
+		pm.mutex.Lock()
+		pm.Processes[h.PID()].Start = time.Now().Add(-age)
+		pm.mutex.Unlock()
+		return h
+	}
+	oldMirror := start(CategoryMirror, time.Hour)
+	youngMirror := start(CategoryMirror, time.Minute)
+	oldGit := start(CategoryGit, time.Hour)
+	// Never started, so there is nothing to kill
+	pid := pm.Add("Old mirror", nil)
+	pm.mutex.Lock()
+	pm.Processes[pid].Category = CategoryMirror
+	pm.Processes[pid].Start = time.Now().Add(-time.Hour)
+	pm.mutex.Unlock()
+
+	killed, errs := pm.KillOlderThanByCategory(30*time.Minute, CategoryMirror)
+	assert.Empty(t, errs)
+	assert.Equal(t, []int64{oldMirror.PID()}, killed)
+	<-oldMirror.Done()
+
+	for _, h := range []*Handle{youngMirror, oldGit} {
+		select {
+		case <-h.Done():
+			t.Errorf("process %d was killed", h.PID())
+		default:
+		}
+		assert.NoError(t, pm.Kill(h.PID()))
+		<-h.Done()
+	}
+	assert.Equal(t, 1, pm.Count(), "the process not started is left alone")
+}
+
+func TestManager_SetDefaultTimeout(t *testing.T) {
+	pm := newFakeManager()
+	assert.Equal(t, DefaultTimeout, pm.defaultTimeout(CategoryGit))
+
+	pm.SetDefaultTimeout(CategoryGit, 10*time.Second)
+	pm.SetDefaultTimeout(CategoryMirror, time.Hour)
+	assert.Equal(t, 10*time.Second, pm.defaultTimeout(CategoryGit))
+	assert.Equal(t, time.Hour, pm.defaultTimeout(CategoryMirror))
+	assert.Equal(t, DefaultTimeout, pm.defaultTimeout(CategoryHook), "other categories fall back to DefaultTimeout")
+
+	pm.SetDefaultTimeout(CategoryMirror, 0)
+	assert.Equal(t, DefaultTimeout, pm.defaultTimeout(CategoryMirror))
+
+	// The default of the category applies to the commands without a timeout, not to the others
+	for _, tc := range []struct {
+		opts    []RunOption
+		timeout time.Duration
+	}{
+		{[]RunOption{WithCategory(CategoryGit)}, 10 * time.Second},
+		{[]RunOption{WithCategory(CategoryGit), WithTimeout(time.Minute)}, time.Minute},
+		{[]RunOption{WithCategory(CategoryMirror)}, DefaultTimeout},
+	} {
+		start := time.Now()
+		h, err := pm.Start("Hang", "hang", nil, tc.opts...)
+		assert.NoError(t, err)
+		deadline, ok := h.proc.Deadline()
+		if assert.True(t, ok) {
+			assert.WithinDuration(t, start.Add(tc.timeout), deadline, time.Second)
+		}
+		assert.NoError(t, pm.Kill(h.PID()))
+		<-h.Done()
+	}
+}
+
+func TestManager_SetMaxConcurrentByCategory(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetMaxConcurrent(3)
+	pm.SetMaxConcurrentByCategory(CategoryMirror, 1)
+
+	started := make(chan *Handle, 4)
+	start := func(c Category) {
+		go func() {
+			h, err := pm.Start("Hang", "hang", nil, WithCategory(c))
+			assert.NoError(t, err)
+			started <- h
+		}()
+	}
+	var handles []*Handle
+	defer func() {
+		for _, h := range handles {
+			_ = pm.Kill(h.PID())
+			<-h.Done()
+		}
+	}()
+
+	// The mirror category is saturated by a single sync
+	start(CategoryMirror)
+	handles = append(handles, <-started)
+	start(CategoryMirror)
+
+	// Git still gets the remaining slots
+	start(CategoryGit)
+	start(CategoryGit)
+	handles = append(handles, <-started, <-started)
+	assert.Equal(t, map[Category]int{CategoryMirror: 1, CategoryGit: 2}, pm.CountByCategory())
+
+	// Until the total limit is reached
+	start(CategoryGit)
+	select {
+	case h := <-started:
+		handles = append(handles, h)
+		t.Fatalf("%s should have waited for a slot", h.proc.Category)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Freeing a git slot lets the waiting git command in, not the sync
+	assert.NoError(t, pm.Kill(handles[1].PID()))
+	<-handles[1].Done()
+	h := <-started
+	handles = append(handles, h)
+	assert.Equal(t, CategoryGit, h.proc.Category)
+	assert.Equal(t, map[Category]int{CategoryMirror: 1, CategoryGit: 2}, pm.CountByCategory())
+
+	pm.SetMaxConcurrent(0)
+	pm.SetMaxConcurrentByCategory(CategoryMirror, 0)
+	handles = append(handles, <-started)
+	assert.Equal(t, map[Category]int{CategoryMirror: 2, CategoryGit: 2}, pm.CountByCategory())
+}
+
+func TestManager_SetKillOrder(t *testing.T) {
+	now := time.Now()
+	pm := NewManagerWithProcesses(nil, []ProcessDescriptor{
+		{PID: 1, Start: now, Category: CategoryGit},
+		{PID: 2, Start: now, Category: CategoryMirror},
+		{PID: 3, Start: now, Category: CategoryHook},
+		{PID: 4, Start: now, Category: CategoryGit},
+		{PID: 5, Start: now, Category: CategoryUnknown},
+		{PID: 6, Start: now, Category: CategoryMirror},
+		{PID: 7, Start: now, Category: CategoryLFS},
+	})
+	order := func() [][]int64 {
+		pm.mutex.Lock()
+		defer pm.mutex.Unlock()
+		return pm.killTiersLocked()
+	}
+
+	// Background work first, user-facing git last
+	assert.Equal(t, [][]int64{{2, 6}, {5}, {7}, {3}, {1, 4}}, order())
+
+	pm.SetKillOrder(CategoryLFS, CategoryGit)
+	assert.Equal(t, [][]int64{{7}, {1, 4}, {2, 3, 5, 6}}, order(), "missing categories come last")
+
+	pm.SetKillOrder()
+	assert.Equal(t, [][]int64{{2, 6}, {5}, {7}, {3}, {1, 4}}, order())
+
+	assert.NoError(t, pm.KillAll())
+	assert.Equal(t, 0, pm.Count())
+}
+
+func TestManager_KillAllWaitsForTier(t *testing.T) {
+	pm := newFakeManager()
+	git, err := pm.Start("Git", "hang", nil, WithCategory(CategoryGit))
+	assert.NoError(t, err)
+	// Added processes are waited for by the caller, which delays the next tier
+	mirror := fakeExecCommand(context.Background(), "hang")
+	assert.NoError(t, mirror.Start())
+	pid := pm.Add("Mirror", mirror)
+	pm.mutex.Lock()
+	pm.Processes[pid].Category = CategoryMirror
+	pm.mutex.Unlock()
+
+	killed := make(chan error, 1)
+	go func() {
+		killed <- pm.KillAll()
+	}()
+	select {
+	case <-git.Done():
+		t.Fatal("git was killed before the mirror exited")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	_ = mirror.Wait()
+	<-git.Done()
+	assert.NoError(t, <-killed)
+	assert.Equal(t, 0, pm.Count())
+}
@@ -0,0 +1,26 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "strings"
+
+// CleanStderr is the default stderr cleaner of WithStderrCleaner, meant for git
+// output shown in the UI: progress lines rewritten with carriage returns are
+// collapsed to their final value and the "remote: " prefix is stripped.
+func CleanStderr(stderr string) string {
+	lines := strings.Split(stderr, "\n")
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if j := strings.LastIndexByte(line, '\r'); j >= 0 {
+			line = line[j+1:]
+		}
+		if strings.HasPrefix(line, "remote: ") {
+			// git pads remote progress lines with spaces to overwrite longer ones
+			line = strings.TrimRight(strings.TrimPrefix(line, "remote: "), " ")
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanStderr(t *testing.T) {
+	raw := "Cloning into 'repo'...\n" +
+		"remote: Counting objects:  50% (1/2)   \rremote: Counting objects: 100% (2/2)   \rremote: Counting objects: 100% (2/2), done.\n" +
+		"Receiving objects:  33% (1/3)\rReceiving objects:  66% (2/3)\rReceiving objects: 100% (3/3), done.\r\n" +
+		"warning: remote: is kept in the middle\n"
+	assert.Equal(t, "Cloning into 'repo'...\n"+
+		"Counting objects: 100% (2/2), done.\n"+
+		"Receiving objects: 100% (3/3), done.\n"+
+		"warning: remote: is kept in the middle\n", CleanStderr(raw))
+}
+
+func TestManager_ExecWithStderrCleaner(t *testing.T) {
+	pm := newFakeManager()
+	progress := "Resolving deltas:  10% (1/10)\rResolving deltas:  50% (5/10)\rResolving deltas: 100% (10/10), done.\n"
+
+	h, err := pm.Start("Progress", "fail", []string{"0", progress}, WithStderrCleaner(nil))
+	assert.NoError(t, err)
+	_, stderr, err := h.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, progress, stderr, "the raw stderr is kept")
+	assert.Equal(t, "Resolving deltas: 100% (10/10), done.\n", h.CleanedStderr())
+
+	_, _, err = pm.ExecWithOptions("Progress", "fail", []string{"1", progress}, WithStderrCleaner(strings.ToUpper))
+	assert.Error(t, err)
+	assert.Equal(t, strings.ToUpper(progress), err.(*ExecError).CleanedStderr)
+}
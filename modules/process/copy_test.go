@@ -0,0 +1,27 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_NoCopy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go vet")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	// testdata is left out of ./... so that go vet of the package stays clean.
+	out, err := exec.Command("go", "vet", "./testdata/copymanager").CombinedOutput()
+	assert.Error(t, err, "go vet must fail on copies of a manager")
+	assert.Contains(t, string(out), "Count passes lock by value")
+	assert.Contains(t, string(out), "call of Count copies lock value")
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"container/heap"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxExtension is how far past its original timeout the deadline of a
+// command can be extended, unless set with WithMaxExtension.
+const DefaultMaxExtension = 10 * time.Minute
+
+// ExtendDeadline pushes the deadline of the process to d from now if that is
+// later than its current deadline, e.g. for commands reporting progress.
+// The deadline is never extended past the maximum extension: it is then extended
+// as far as possible and ErrDeadlineLimit is returned. ErrExecTimeout is returned
+// if the deadline has already expired and ErrNoDeadline for processes added
+// to the manager rather than started by it.
+func (p *Process) ExtendDeadline(d time.Duration) error {
+	if p.deadline == nil {
+		return ErrNoDeadline
+	}
+	return p.deadline.extend(d)
+}
+
+// Deadline returns when the process will be killed as things stand: when its timeout,
+// as extended so far, expires or at the deadline of its context if that comes first.
+// It returns false for processes added to the manager rather than started by it.
+func (p *Process) Deadline() (time.Time, bool) {
+	if p.deadline == nil {
+		return time.Time{}, false
+	}
+	return p.deadline.when(), true
+}
+
+// deadline is a timeout that can be extended, calling cancel when it expires.
+type deadline struct {
+	mutex   sync.Mutex
+	cancel  func()
+	expires time.Time
+	limit   time.Time // expires is never extended past it
+	expired bool
+
+	ctxDeadline time.Time // deadline of the parent context, if any, set before use
+
+	// guarded by the mutex of deadlines
+	at    time.Time // when deadlines fires it, expires as last scheduled
+	index int       // in the heap of deadlines, -1 if not scheduled
+}
+
+func newDeadline(timeout, maxExtension time.Duration, cancel func()) *deadline {
+	now := time.Now()
+	dl := &deadline{
+		cancel:  cancel,
+		expires: now.Add(timeout),
+		limit:   now.Add(timeout + maxExtension),
+		index:   -1,
+	}
+	deadlines.schedule(dl, dl.expires)
+	return dl
+}
+
+// fire cancels the command unless its deadline was extended since it was scheduled.
+func (dl *deadline) fire() {
+	dl.mutex.Lock()
+	if time.Now().Before(dl.expires) {
+		deadlines.schedule(dl, dl.expires)
+		dl.mutex.Unlock()
+		return
+	}
+	dl.expired = true
+	dl.mutex.Unlock()
+	dl.cancel()
+}
+
+func (dl *deadline) extend(d time.Duration) error {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	if dl.expired {
+		return ErrExecTimeout
+	}
+	var err error
+	expires := time.Now().Add(d)
+	if expires.After(dl.limit) {
+		expires, err = dl.limit, ErrDeadlineLimit
+	}
+	if expires.After(dl.expires) {
+		dl.expires = expires
+		deadlines.schedule(dl, expires)
+	}
+	return err
+}
+
+// when returns when the command will be killed, unless extended.
+func (dl *deadline) when() time.Time {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	if !dl.ctxDeadline.IsZero() && dl.ctxDeadline.Before(dl.expires) {
+		return dl.ctxDeadline
+	}
+	return dl.expires
+}
+
+// exceeded returns whether the deadline expired.
+func (dl *deadline) exceeded() bool {
+	dl.mutex.Lock()
+	defer dl.mutex.Unlock()
+	return dl.expired
+}
+
+func (dl *deadline) stop() {
+	deadlines.unschedule(dl)
+}
+
+// deadlines fires the deadlines of all the commands from a single timer, instead of
+// allocating one per command: they are kept in a heap by when they expire.
+var deadlines deadlineTimer
+
+type deadlineTimer struct {
+	mutex sync.Mutex
+	heap  deadlineHeap
+	timer *time.Timer
+	next  time.Time // when timer fires, zero if stopped
+}
+
+// schedule fires dl at the given time, rescheduling it if it already is.
+func (dt *deadlineTimer) schedule(dl *deadline, at time.Time) {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+	dl.at = at
+	if dl.index >= 0 {
+		heap.Fix(&dt.heap, dl.index)
+	} else {
+		heap.Push(&dt.heap, dl)
+	}
+	dt.resetLocked()
+}
+
+func (dt *deadlineTimer) unschedule(dl *deadline) {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+	if dl.index >= 0 {
+		heap.Remove(&dt.heap, dl.index)
+		dt.resetLocked()
+	}
+}
+
+// fire fires the expired deadlines, out of the mutex as they may reschedule themselves.
+func (dt *deadlineTimer) fire() {
+	var expired []*deadline
+	dt.mutex.Lock()
+	now := time.Now()
+	for len(dt.heap) > 0 && !dt.heap[0].at.After(now) {
+		expired = append(expired, heap.Pop(&dt.heap).(*deadline))
+	}
+	dt.next = time.Time{}
+	dt.resetLocked()
+	dt.mutex.Unlock()
+
+	for _, dl := range expired {
+		dl.fire()
+	}
+}
+
+// resetLocked sets the timer to fire with the earliest deadline.
+func (dt *deadlineTimer) resetLocked() {
+	if len(dt.heap) == 0 {
+		if dt.timer != nil {
+			dt.timer.Stop()
+		}
+		dt.next = time.Time{}
+		return
+	}
+	at := dt.heap[0].at
+	if at.Equal(dt.next) {
+		return
+	}
+	dt.next = at
+	if dt.timer == nil {
+		dt.timer = time.AfterFunc(time.Until(at), dt.fire)
+	} else {
+		dt.timer.Reset(time.Until(at))
+	}
+}
+
+// deadlineHeap implements heap.Interface, the earliest deadline first.
+type deadlineHeap []*deadline
+
+func (h deadlineHeap) Len() int           { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *deadlineHeap) Push(x interface{}) {
+	dl := x.(*deadline)
+	dl.index = len(*h)
+	*h = append(*h, dl)
+}
+
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	dl := old[len(old)-1]
+	old[len(old)-1] = nil
+	dl.index = -1
+	*h = old[:len(old)-1]
+	return dl
+}
+
+// startupTimeout cancels a command which hasn't produced any output once it expires.
+type startupTimeout struct {
+	timer   *time.Timer
+	expired int32 // accessed atomically
+}
+
+// newStartupTimeout relies on the output counters of proc, fed as the command writes.
+func newStartupTimeout(d time.Duration, proc *Process, cancel func()) *startupTimeout {
+	st := &startupTimeout{}
+	st.timer = time.AfterFunc(d, func() {
+		if stdout, stderr := proc.OutputBytes(); stdout+stderr == 0 {
+			atomic.StoreInt32(&st.expired, 1)
+			cancel()
+		}
+	})
+	return st
+}
+
+// exceeded returns whether the command was canceled by the startup timeout.
+func (st *startupTimeout) exceeded() bool {
+	return atomic.LoadInt32(&st.expired) == 1
+}
+
+// heartbeatWriter extends a deadline on every write.
+type heartbeatWriter struct {
+	w        io.Writer
+	deadline *deadline
+	d        time.Duration
+}
+
+func (hw *heartbeatWriter) Write(p []byte) (int, error) {
+	_ = hw.deadline.extend(hw.d)
+	return hw.w.Write(p)
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcess_ExtendDeadline(t *testing.T) {
+	pm := newFakeManager()
+
+	h, err := pm.Start("Extended", "hang", nil, WithTimeout(200*time.Millisecond))
+	assert.NoError(t, err)
+	pm.mutex.Lock()
+	proc := pm.Processes[h.PID()]
+	pm.mutex.Unlock()
+
+	// Extended periodically, it outlives its original timeout
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(t, proc.ExtendDeadline(200*time.Millisecond))
+	}
+	select {
+	case <-h.Done():
+		t.Fatal("the process was killed despite the extensions")
+	default:
+	}
+
+	// Once it isn't extended anymore, it times out
+	_, _, err = h.Wait()
+	assert.True(t, err.(*ExecError).Is(ErrExecTimeout))
+	assert.Equal(t, ErrExecTimeout, proc.ExtendDeadline(time.Second))
+	assert.Equal(t, int64(1), pm.Stats().TimedOut)
+
+	assert.Equal(t, ErrNoDeadline, (&Process{}).ExtendDeadline(time.Second))
+}
+
+func TestProcess_ExtendDeadlineLimit(t *testing.T) {
+	pm := newFakeManager()
+
+	h, err := pm.Start("Limited", "hang", nil, WithTimeout(100*time.Millisecond), WithMaxExtension(200*time.Millisecond))
+	assert.NoError(t, err)
+	pm.mutex.Lock()
+	proc := pm.Processes[h.PID()]
+	pm.mutex.Unlock()
+
+	start := time.Now()
+	assert.Equal(t, ErrDeadlineLimit, proc.ExtendDeadline(time.Minute))
+	_, _, err = h.Wait()
+	assert.True(t, err.(*ExecError).Is(ErrExecTimeout))
+	assert.True(t, time.Since(start) < 5*time.Second, "the deadline was extended past its limit")
+}
+
+func TestProcess_Deadline(t *testing.T) {
+	pm := newFakeManager()
+
+	h, err := pm.Start("Hang", "hang", nil, WithTimeout(30*time.Second))
+	assert.NoError(t, err)
+	deadline, ok := h.proc.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, h.proc.Start.Add(30*time.Second), deadline, time.Second)
+
+	assert.NoError(t, h.proc.ExtendDeadline(time.Minute))
+	deadline, _ = h.proc.Deadline()
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	snap := pm.Snapshot()
+	if assert.Len(t, snap.Processes, 1) {
+		snapDeadline, ok := snap.Processes[0].Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, deadline, snapDeadline)
+	}
+	assert.NoError(t, pm.Kill(h.PID()))
+	<-h.Done()
+
+	// The deadline of the context comes first
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h, err = pm.Start("Hang", "hang", nil, WithContext(ctx))
+	assert.NoError(t, err)
+	ctxDeadline, _ := ctx.Deadline()
+	deadline, ok = h.proc.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, ctxDeadline, deadline)
+	assert.NoError(t, pm.Kill(h.PID()))
+	<-h.Done()
+
+	_, ok = (&Process{}).Deadline()
+	assert.False(t, ok, "added processes have no deadline")
+}
+
+func TestWithTimeouts(t *testing.T) {
+	pm := newFakeManager()
+
+	// Silent for too long after starting
+	start := time.Now()
+	_, _, err := pm.ExecWithOptions("Hang", "hang", nil, WithTimeouts(Timeouts{Startup: 200 * time.Millisecond, Total: 10 * time.Second}))
+	assert.True(t, errors.Is(err, ErrStartupTimeout))
+	assert.True(t, errors.Is(err, ErrExecTimeout))
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// Started in time, but too long in total
+	_, _, err = pm.ExecWithOptions("Lines", "lines", []string{"20", "100"}, WithTimeouts(Timeouts{Startup: time.Second, Total: 500 * time.Millisecond}))
+	assert.True(t, errors.Is(err, ErrExecTimeout))
+	assert.False(t, errors.Is(err, ErrStartupTimeout))
+
+	// Started in time and running past the startup timeout
+	stdout, _, err := pm.ExecWithOptions("Lines", "lines", []string{"3", "500"}, WithTimeouts(Timeouts{Startup: time.Second}))
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\nline 3\n", stdout)
+	assert.Equal(t, int64(2), pm.Stats().TimedOut)
+}
+
+func TestManager_ExecWithHeartbeat(t *testing.T) {
+	pm := newFakeManager()
+
+	// A line every 100ms keeps the command alive for longer than its timeout
+	h, err := pm.Start("Progress", "lines", []string{"100", "100"},
+		WithTimeout(time.Second), WithHeartbeat(500*time.Millisecond))
+	assert.NoError(t, err)
+	time.Sleep(2500 * time.Millisecond)
+	select {
+	case <-h.Done():
+		t.Fatal("the process was killed despite its progress")
+	default:
+	}
+	assert.NoError(t, pm.Kill(h.PID()))
+	<-h.Done()
+
+	// A stall kills it
+	stdout, _, err := pm.ExecWithOptions("Stall", "lines", []string{"2", "5000"},
+		WithTimeout(2*time.Second), WithHeartbeat(500*time.Millisecond))
+	assert.Error(t, err)
+	assert.True(t, err.(*ExecError).Is(ErrExecTimeout))
+	assert.Equal(t, "line 1\n", stdout)
+}
+
+func TestWithAbsoluteDeadline(t *testing.T) {
+	pm := newFakeManager()
+
+	at := time.Now().Add(300 * time.Millisecond)
+	h, err := pm.Start("Hang", "hang", nil, WithTimeout(10*time.Second), WithMaxExtension(time.Minute),
+		WithAbsoluteDeadline(at.Add(time.Hour)), WithAbsoluteDeadline(at))
+	assert.NoError(t, err)
+	deadline, ok := h.proc.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, at, deadline, "the earliest deadline applies")
+	assert.NoError(t, h.proc.ExtendDeadline(30*time.Second))
+
+	_, _, err = h.Wait()
+	assert.True(t, errors.Is(err, ErrExecTimeout))
+	assert.Equal(t, StateTimedOut, h.Result().State)
+	assert.True(t, time.Since(at) < 5*time.Second, "the absolute deadline must fire before the timeout")
+}
+
+func TestDeadlineTimer(t *testing.T) {
+	fired := make(chan int, 3)
+	newTestDeadline := func(i int, timeout time.Duration) *deadline {
+		return newDeadline(timeout, time.Minute, func() { fired <- i })
+	}
+	// Scheduled in any order, the deadlines share the timer and fire in order
+	late := newTestDeadline(3, 300*time.Millisecond)
+	stopped := newTestDeadline(2, 100*time.Millisecond)
+	early := newTestDeadline(1, 50*time.Millisecond)
+	extended := newTestDeadline(4, 100*time.Millisecond)
+	stopped.stop()
+	assert.NoError(t, extended.extend(400*time.Millisecond))
+
+	for _, want := range []int{1, 3, 4} {
+		select {
+		case i := <-fired:
+			assert.Equal(t, want, i)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("deadline %d did not fire", want)
+		}
+	}
+	assert.True(t, early.exceeded())
+	assert.True(t, late.exceeded())
+	assert.False(t, stopped.exceeded())
+	assert.Equal(t, ErrExecTimeout, extended.extend(time.Second))
+
+	deadlines.mutex.Lock()
+	defer deadlines.mutex.Unlock()
+	assert.Empty(t, deadlines.heap)
+}
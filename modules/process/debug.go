@@ -0,0 +1,37 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// SetDebugStacks makes the manager record the stack of the goroutine which adds each
+// process, and then of the one waiting for it with Handle.Wait, as shown by ProcessesCopy,
+// so that wedged processes can be matched with stuck goroutines in a goroutine dump.
+// It costs a stack trace per process, hence it is off by default.
+func (pm *Manager) SetDebugStacks(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&pm.debugStacks, v)
+}
+
+// callerStack returns the stack of the calling goroutine if debug stacks are enabled.
+func (pm *Manager) callerStack() string {
+	if atomic.LoadInt32(&pm.debugStacks) == 0 {
+		return ""
+	}
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
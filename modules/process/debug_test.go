@@ -0,0 +1,41 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForDebugStack(h *Handle) {
+	h.Wait()
+}
+
+func TestManager_SetDebugStacks(t *testing.T) {
+	pm := newFakeManager()
+
+	pid := pm.Add("off", exec.Command("foo"))
+	assert.Empty(t, pm.ProcessesCopy()[0].Stack)
+	pm.Remove(pid)
+
+	pm.SetDebugStacks(true)
+	pid = pm.Add("on", exec.Command("foo"))
+	assert.Contains(t, pm.ProcessesCopy()[0].Stack, "TestManager_SetDebugStacks")
+	pm.Remove(pid)
+
+	h, err := pm.Start("Hang", "hang", nil)
+	assert.NoError(t, err)
+	go waitForDebugStack(h)
+	eventually(t, func() bool {
+		infos := pm.ProcessesCopy()
+		return len(infos) == 1 && strings.Contains(infos[0].Stack, "waitForDebugStack")
+	}, 5*time.Second, 10*time.Millisecond, "the stack must be the one of the waiter")
+	assert.NoError(t, pm.Kill(h.PID()))
+	<-h.Done()
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "sync"
+
+// WithDedupKey makes concurrent commands run with the same key share a single process:
+// while one is running, the others wait for it and get its result and error, including
+// its PID, instead of being started. It is only safe for idempotent reads given the same
+// key for the same command, e.g. "git log" of a popular page, whose key would hold the
+// repository, revision and arguments. The options of the command actually run apply,
+// so it must not be combined with options sending the output elsewhere than the result,
+// like WithStdoutWriter or WithStdoutBuffer. It has no effect on Start.
+func WithDedupKey(key string) RunOption {
+	return func(o *runOptions) {
+		o.dedupKey = key
+	}
+}
+
+// flight is a command run on behalf of all the callers with its dedup key.
+type flight struct {
+	done   chan struct{}
+	result Result
+	err    error
+}
+
+// flights tracks the commands in flight by dedup key, like golang.org/x/sync/singleflight.
+type flights struct {
+	mutex sync.Mutex
+	byKey map[string]*flight
+}
+
+// do runs fn unless a command with the same key is in flight, in which case it waits for
+// it instead. A waiting caller whose context is done returns its error.
+func (fs *flights) do(key string, o *runOptions, fn func() (Result, error)) (Result, error) {
+	fs.mutex.Lock()
+	if f, ok := fs.byKey[key]; ok {
+		fs.mutex.Unlock()
+		if o.ctx == nil {
+			<-f.done
+			return f.share()
+		}
+		select {
+		case <-f.done:
+			return f.share()
+		case <-o.ctx.Done():
+			return Result{}, o.ctx.Err()
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	if fs.byKey == nil {
+		fs.byKey = make(map[string]*flight)
+	}
+	fs.byKey[key] = f
+	fs.mutex.Unlock()
+
+	defer func() {
+		fs.mutex.Lock()
+		delete(fs.byKey, key)
+		fs.mutex.Unlock()
+		close(f.done)
+	}()
+	f.result, f.err = fn()
+	return f.share()
+}
+
+// share returns copies of the result and error of the flight to one of its callers, so that
+// each caller can change its own, e.g. ExecRetry recording its attempts.
+func (f *flight) share() (Result, error) {
+	if execErr, ok := f.err.(*ExecError); ok {
+		shared := *execErr
+		return f.result, &shared
+	}
+	return f.result, f.err
+}
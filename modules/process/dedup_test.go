@@ -0,0 +1,89 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDedupKey(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	const n = 5
+	results := make([]Result, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			results[i], err = pm.ExecResult("Log", "lines", []string{"3", "300"}, WithDedupKey("log repo master"))
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, pm.History(), 1, "the command should have been run once")
+	for _, r := range results {
+		assert.Equal(t, "line 1\nline 2\nline 3\n", r.Stdout)
+		assert.Equal(t, results[0].PID, r.PID)
+	}
+
+	// Once done, the command is run again, and other keys run separately
+	_, err := pm.ExecResult("Log", "echo", []string{"again"}, WithDedupKey("log repo master"))
+	assert.NoError(t, err)
+	_, err = pm.ExecResult("Log", "echo", []string{"other"}, WithDedupKey("log repo other"))
+	assert.NoError(t, err)
+	assert.Len(t, pm.History(), 3)
+
+	// A waiting caller can give up
+	done := make(chan struct{})
+	go func() {
+		_, _ = pm.ExecResult("Hang", "hang", []string{"2000"}, WithDedupKey("hang"))
+		close(done)
+	}()
+	eventually(t, func() bool { return pm.Count() == 1 }, 5*time.Second, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = pm.ExecResult("Hang", "hang", []string{"2000"}, WithDedupKey("hang"), WithContext(ctx))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, pm.Count())
+	<-done
+}
+
+func TestWithDedupKey_Retry(t *testing.T) {
+	pm := newFakeManager()
+
+	const n = 3
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = pm.ExecRetry(2, func(error) bool { return true }, "Hang", "hang", []string{"2000"},
+				WithDedupKey("hang"), WithTimeout(200*time.Millisecond))
+		}(i)
+	}
+	wg.Wait()
+
+	// Each caller gets its own error, with its own attempts
+	for i, err := range errs {
+		execErr, ok := err.(*ExecError)
+		if assert.True(t, ok) {
+			assert.Equal(t, 2, execErr.Attempts)
+			assert.Len(t, execErr.AttemptErrors, 1)
+			for _, other := range errs[i+1:] {
+				assert.True(t, execErr != other)
+			}
+		}
+	}
+}
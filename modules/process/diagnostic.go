@@ -0,0 +1,71 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"os"
+)
+
+// DiagnosticTransform returns the args and env of the diagnostic run of a failed
+// command from its own ones. A nil env stands for the inherited environment.
+type DiagnosticTransform func(args, env []string) ([]string, []string)
+
+// GitTraceTransform is the default DiagnosticTransform: it enables GIT_TRACE.
+func GitTraceTransform(args, env []string) ([]string, []string) {
+	if env == nil {
+		env = os.Environ()
+	}
+	return args, mergeEnv(env, []string{"GIT_TRACE=1"})
+}
+
+// WithDiagnosticRetry runs a failed command once more with the args and env returned by
+// transform, GitTraceTransform if nil, and attaches the merged stdout and stderr of that
+// run to the ExecError as DiagnosticOutput, to diagnose flaky failures. The failed process
+// stays tracked during the diagnostic run. Commands fed with WithStdin, which can't be
+// read twice, or killed on purpose through the manager or their context aren't re-run.
+func WithDiagnosticRetry(transform DiagnosticTransform) RunOption {
+	return func(o *runOptions) {
+		if transform == nil {
+			transform = GitTraceTransform
+		}
+		o.diagnosticTransform = transform
+	}
+}
+
+// diagnosticRun is what is needed to re-run a command for diagnosis.
+type diagnosticRun struct {
+	cmdName string
+	args    []string
+	o       runOptions // as given, before start completes and applies them
+}
+
+// diagnose runs the diagnostic run of the failed command and returns its output.
+func (h *Handle) diagnose() string {
+	d := h.diagnostic
+	o := d.o
+	args, env := o.diagnosticTransform(d.args, o.env)
+	o.env = env
+	o.diagnosticTransform = nil
+	// The outputs of the diagnostic run only go to DiagnosticOutput.
+	o.stdout = nil
+	o.stdoutBuffer = nil
+	o.stderrBuffer = nil
+	o.progress = nil
+	o.stdin = nil
+	o.stdinLimit = 0
+	o.stderrToStdout = true
+	o.stderrCleaner = nil
+	o.afterStart = nil
+	o.onFinish = nil
+	o.pidFile = ""
+
+	dh, err := h.pm.startWithOptions(h.proc.CurrentDescription()+" (diagnostic)", d.cmdName, args, o)
+	if err != nil {
+		return fmt.Sprintf("diagnostic run failed to start: %v", err)
+	}
+	dh.wait()
+	return dh.stdout
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// drainTimeout bounds how long the outputs of a killed command are still read once it
+// has exited: its own children, e.g. the remote helpers of git, may keep them open.
+const drainTimeout = time.Second
+
+// errDetached is returned to the copy of an output which has been given up on.
+var errDetached = errors.New("output detached")
+
+// outputPipe copies an output of the command from a pipe owned by the manager rather
+// than by exec, so that whatever the command wrote before exiting is read, while the
+// copy can still be given up on if children of a killed command keep the pipe open.
+// Each output has its own copy, and the outputs are only drained once the command has
+// exited, so a command closing its stdout early and then writing to stderr is fully read.
+type outputPipe struct {
+	r, w *os.File
+	done chan struct{}
+
+	mutex    sync.Mutex
+	dst      io.Writer
+	detached bool
+}
+
+// pipeOutput returns the write end of a pipe, for the command, whose content is copied to dst.
+func (h *Handle) pipeOutput(dst io.Writer) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &outputPipe{r: r, w: w, dst: dst, done: make(chan struct{})}
+	h.pipes = append(h.pipes, p)
+	go p.copy()
+	return w, nil
+}
+
+func (p *outputPipe) copy() {
+	defer close(p.done)
+	buf := getCopyBuffer()
+	// The reader is wrapped for the copy to go through the pooled buffer, not WriteTo.
+	_, _ = io.CopyBuffer(p, struct{ io.Reader }{p.r}, *buf)
+	putCopyBuffer(buf)
+	_ = p.r.Close()
+}
+
+func (p *outputPipe) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.detached {
+		return 0, errDetached
+	}
+	return p.dst.Write(b)
+}
+
+// closeWriters closes the write ends of the pipes, which only the command must hold
+// once started so that the copies end when it exits.
+func (h *Handle) closeWriters() {
+	for _, p := range h.pipes {
+		_ = p.w.Close()
+	}
+}
+
+// drain waits for the outputs to have been copied. If the command was killed, it waits
+// at most drainTimeout, else WithPostExitDrainDelay if set, and then detaches the copies,
+// which stop writing at once.
+func (h *Handle) drain(killed bool) {
+	var timeout <-chan time.Time
+	d := h.drainDelay
+	if killed {
+		d = drainTimeout
+	}
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	expired := false
+	for _, p := range h.pipes {
+		if !expired {
+			select {
+			case <-p.done:
+				continue
+			case <-timeout:
+				expired = true
+			}
+		}
+		p.mutex.Lock()
+		p.detached = true
+		p.mutex.Unlock()
+		// Unblocks the copy where pipes support deadlines, else it ends with the children.
+		_ = p.r.SetReadDeadline(time.Now())
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadEnvFile reads a dotenv-style file of KEY=VALUE lines into the KEY=value format
+// of command environments. Blank lines and lines starting with # are ignored, as is
+// an "export " prefix. Values may be single-quoted, taken literally, or double-quoted,
+// where \", \\ and \n are unescaped. Unquoted values end at a # preceded by a space.
+func LoadEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		kv, err := parseEnvLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		env = append(env, kv)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// parseEnvLine parses a non-blank, non-comment line of an env file into KEY=value.
+func parseEnvLine(line string) (string, error) {
+	line = strings.TrimPrefix(line, "export ")
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return "", fmt.Errorf("missing = in %q", line)
+	}
+	key := strings.TrimSpace(line[:i])
+	if !isEnvName(key) {
+		return "", fmt.Errorf("invalid variable name %q", key)
+	}
+
+	value := strings.TrimSpace(line[i+1:])
+	var rest string
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quote in the value of %s", key)
+		}
+		value, rest = value[1:end+1], value[end+2:]
+	case strings.HasPrefix(value, `"`):
+		var unquoted strings.Builder
+		end := -1
+		for j := 1; j < len(value); j++ {
+			c := value[j]
+			if c == '"' {
+				end = j
+				break
+			}
+			if c == '\\' && j+1 < len(value) {
+				j++
+				switch value[j] {
+				case 'n':
+					c = '\n'
+				case '"', '\\':
+					c = value[j]
+				default:
+					unquoted.WriteByte('\\')
+					c = value[j]
+				}
+			}
+			unquoted.WriteByte(c)
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quote in the value of %s", key)
+		}
+		value, rest = unquoted.String(), value[end+1:]
+	default:
+		if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %q after the quoted value of %s", rest, key)
+	}
+	return key + "=" + value, nil
+}
+
+// isEnvName reports whether name is a valid shell variable name.
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// WithEnvFile merges the variables of the env file at path, read with LoadEnvFile,
+// into the environment set so far, like WithMergedEnv. The command fails to start
+// if the file can't be read or parsed.
+func WithEnvFile(path string) RunOption {
+	return func(o *runOptions) {
+		vars, err := LoadEnvFile(path)
+		if err != nil {
+			o.err = fmt.Errorf("invalid env file: %v", err)
+			return
+		}
+		WithMergedEnv(vars...)(o)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeEnvFile(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "hooks.env")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeEnvFile(t, dir, `# Hook environment
+
+PLAIN=value
+export EXPORTED=1
+SPACED = padded value   # a comment
+HASH=a#b
+EMPTY=
+SINGLE='literal # \n'
+DOUBLE="say \"hi\"\nC:\\dir\x" # a comment
+EQUALS=a=b
+`)
+	env, err := LoadEnvFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"PLAIN=value",
+		"EXPORTED=1",
+		"SPACED=padded value",
+		"HASH=a#b",
+		"EMPTY=",
+		"SINGLE=literal # \\n",
+		"DOUBLE=say \"hi\"\nC:\\dir\\x",
+		"EQUALS=a=b",
+	}, env)
+
+	for content, message := range map[string]string{
+		"NOVALUE\n":        "hooks.env:1: missing = in \"NOVALUE\"",
+		"A=1\n1A=2\n":      "hooks.env:2: invalid variable name \"1A\"",
+		"A B=1\n":          "hooks.env:1: invalid variable name \"A B\"",
+		"A=\"unclosed\n":   "hooks.env:1: unterminated quote in the value of A",
+		"A='unclosed\n":    "hooks.env:1: unterminated quote in the value of A",
+		"A=\"quoted\" x\n": "hooks.env:1: unexpected \"x\" after the quoted value of A",
+	} {
+		_, err := LoadEnvFile(writeEnvFile(t, dir, content))
+		if assert.Error(t, err, content) {
+			assert.Equal(t, filepath.Join(dir, message), err.Error(), content)
+		}
+	}
+
+	_, err = LoadEnvFile(filepath.Join(dir, "missing.env"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWithEnvFile(t *testing.T) {
+	pm := newFakeManager()
+	dir, err := ioutil.TempDir("", "envfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeEnvFile(t, dir, "PROCESS_TEST_SET='from file'\n")
+	stdout, _, err := pm.ExecWithOptions("Env", "env", []string{"PROCESS_TEST_SET", "PROCESS_TEST_OTHER"},
+		WithCleanEnv(), WithMergedEnv("PROCESS_TEST_OTHER=1"), WithEnvFile(path))
+	assert.NoError(t, err)
+	assert.Equal(t, "PROCESS_TEST_SET=from file\nPROCESS_TEST_OTHER=1\n", stdout)
+
+	_, _, err = pm.ExecWithOptions("Env", "env", nil, WithEnvFile(filepath.Join(dir, "missing.env")))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid env file")
+	assert.Equal(t, 0, pm.Count())
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// ExecError is the error returned when a command run by the manager fails.
+// Its fields are part of the package API and can be read with errors.As.
+type ExecError struct {
+	PID         int64
+	Description string
+	RequestID   string // set by WithRequestID
+	InstanceID  string // instance ID of the manager
+	// ExitCode is the exit code of the command, or -1 if it did not exit normally (e.g. it was killed).
+	ExitCode int
+	Duration time.Duration
+	// Signaled is set, along with Signal, if the command was terminated by a signal.
+	// It is always false on Windows.
+	Signaled bool
+	Signal   syscall.Signal
+	// Cause is the underlying error, usually an *exec.ExitError.
+	Cause  error
+	Stdout string
+	Stderr string
+	// CleanedStderr is Stderr as cleaned by WithStderrCleaner, if the option was given.
+	CleanedStderr string
+	// OOMKilled is a best-effort guess that the command was killed by the Linux OOM killer:
+	// it died from SIGKILL although neither its timeout nor the manager killed it.
+	// Any other SIGKILL sent from outside Gitea looks the same, and it is always false on Windows.
+	OOMKilled bool
+	// DiagnosticOutput is the output of the diagnostic run requested by WithDiagnosticRetry.
+	DiagnosticOutput string
+	// Attempts and AttemptErrors are set by ExecRetry as in Result.
+	Attempts      int
+	AttemptErrors []error
+	// Reason is why the command was killed, when it was killed with a reason or by its timeout,
+	// see KillWithReason.
+	Reason string
+
+	formattedPID string
+	ctxErr       error
+}
+
+func (e *ExecError) Error() string {
+	var cause interface{} = e.Cause
+	if e.Signaled {
+		cause = fmt.Sprintf("terminated by signal %s (%d)", signalName(e.Signal), e.Signal)
+	}
+	var tags string
+	if e.InstanceID != "" {
+		tags += ", instance " + e.InstanceID
+	}
+	if e.RequestID != "" {
+		tags += ", request " + e.RequestID
+	}
+	if e.Reason != "" {
+		tags += ", killed: " + e.Reason
+	}
+	return fmt.Sprintf("exec(%s:%s%s) failed: %v(%v) stdout: %v stderr: %v", e.formattedPID, e.Description, tags, cause, e.ctxErr, e.Stdout, e.Stderr)
+}
+
+// Unwrap returns the underlying error.
+func (e *ExecError) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports ErrExecTimeout as matching if the command was killed because its timeout expired,
+// and ErrStartupTimeout if that was its startup timeout.
+func (e *ExecError) Is(target error) bool {
+	switch target {
+	case ErrExecTimeout:
+		return e.ctxErr == context.DeadlineExceeded || e.ctxErr == ErrStartupTimeout
+	case ErrStartupTimeout:
+		return e.ctxErr == ErrStartupTimeout
+	}
+	return false
+}
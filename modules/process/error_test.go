@@ -0,0 +1,89 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecError(t *testing.T) {
+	pm := newFakeManager()
+
+	_, _, err := pm.Exec("FailingCommand", "fail", "3", "boom")
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, int64(1), execErr.PID)
+		assert.Equal(t, "FailingCommand", execErr.Description)
+		assert.Equal(t, 3, execErr.ExitCode)
+		assert.True(t, execErr.Duration > 0)
+		assert.Equal(t, "boom", execErr.Stderr)
+		assert.Empty(t, execErr.Stdout)
+
+		var exitErr *exec.ExitError
+		assert.True(t, errors.As(err, &exitErr), "Unwrap should return the cause")
+		assert.Equal(t, execErr.Cause, errors.Unwrap(err))
+		assert.False(t, errors.Is(err, ErrExecTimeout))
+	}
+	assert.Equal(t, "exec(1:FailingCommand) failed: exit status 3(<nil>) stdout:  stderr: boom", err.Error())
+
+	_, _, err = pm.ExecTimeout(100*time.Millisecond, "HangingCommand", "hang")
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, int64(2), execErr.PID)
+		assert.Equal(t, -1, execErr.ExitCode)
+		assert.True(t, errors.Is(err, ErrExecTimeout))
+	}
+}
+
+func TestExecError_RequestID(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	_, _, err := pm.ExecWithOptions("FailingCommand", "fail", []string{"3", "boom"}, WithRequestID("req-42"))
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "req-42", execErr.RequestID)
+	}
+	assert.Equal(t, "exec(1:FailingCommand, request req-42) failed: exit status 3(<nil>) stdout:  stderr: boom", err.Error())
+
+	history := pm.History()
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "req-42", history[0].RequestID)
+	}
+}
+
+func TestExecError_InstanceID(t *testing.T) {
+	pm := newFakeManager()
+	pm.Name = "web"
+	pm.InstanceID = "node-2"
+	pm.SetHistorySize(10)
+
+	_, _, err := pm.ExecWithOptions("FailingCommand", "fail", []string{"3", "boom"}, WithRequestID("req-42"))
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "node-2", execErr.InstanceID)
+	}
+	assert.Equal(t, "exec(web#1:FailingCommand, instance node-2, request req-42) failed: exit status 3(<nil>) stdout:  stderr: boom", err.Error())
+
+	history := pm.History()
+	if assert.Len(t, history, 1) {
+		data, err := json.Marshal(history[0])
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"InstanceID":"node-2"`)
+	}
+
+	pid := pm.Add("Added", nil)
+	snap := pm.Snapshot()
+	assert.Equal(t, "node-2", snap.InstanceID)
+	if assert.Len(t, snap.Processes, 1) {
+		assert.Equal(t, "node-2", snap.Processes[0].InstanceID)
+	}
+	pm.Remove(pid)
+}
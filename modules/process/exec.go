@@ -0,0 +1,573 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// DefaultTimeout is the timeout used when -1 is given as timeout, unless SetDefaultTimeout
+// sets another for the category of the command.
+const DefaultTimeout = 60 * time.Second
+
+// Handle is a command started by the manager.
+type Handle struct {
+	pm     *Manager
+	pid    int64
+	proc   *Process
+	cmd    *exec.Cmd
+	ctx    context.Context
+	cancel context.CancelFunc
+	dl     *deadline
+	st     *startupTimeout // nil without a startup timeout
+	span   Span
+	start  time.Time
+
+	stdoutBuf, stderrBuf *bytes.Buffer // only read once the outputs are drained, see wait
+	callerStdout         bool          // stdoutBuf was given by WithStdoutBuffer
+	callerStderr         bool          // stderrBuf was given by WithStderrBuffer
+	stdinDone            chan struct{}
+	stdinExceeded        int32  // accessed atomically, see WithStdinLimit
+	stdinErr             error  // of the copy to stdin, set before stdinDone is closed
+	stdinCleanup         func() // removes the stdin buffered by WithBufferedStdin
+	budget               outputBudget
+	flushers             []*newlineWriter // flushed once the command has exited
+	stderrCleaner        func(string) string
+	failOnStderr         bool
+	onFinish             []func(stdout, stderr string, err error)
+	onExit               func()
+	pidFile              string // removed once the process has exited, if it could be written
+	diagnostic           *diagnosticRun
+	breakerKey           string // see SetCircuitBreaker
+
+	stdoutWriter, stderrWriter countingWriter // see outputWriter
+	pipes                      []*outputPipe
+	drainDelay                 time.Duration // see WithPostExitDrainDelay
+
+	// set by wait before done is closed
+	done           chan struct{}
+	stdout, stderr string
+	cleanedStderr  string
+	exitCode       int
+	duration       time.Duration
+	state          TerminationState
+	killReason     string
+	err            error
+}
+
+// Start starts a command and returns without waiting for it. The process is
+// tracked until it exits, independently of whether Wait is ever called.
+func (pm *Manager) Start(desc, cmdName string, args []string, opts ...RunOption) (*Handle, error) {
+	h, err := pm.start(desc, cmdName, args, opts)
+	if err != nil {
+		return nil, err
+	}
+	go h.wait()
+	return h, nil
+}
+
+// StartWithLifecycleContext starts a command like Start, with parent as its parent
+// context, and returns a context canceled once the process has exited or been killed,
+// so that goroutines and cleanups can be tied to the lifetime of the process.
+func (pm *Manager) StartWithLifecycleContext(parent context.Context, desc, cmdName string, args []string, opts ...RunOption) (context.Context, int64, error) {
+	opts = append(opts[:len(opts):len(opts)], WithContext(parent))
+	h, err := pm.Start(desc, cmdName, args, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		<-h.Done()
+		cancel()
+	}()
+	return ctx, h.pid, nil
+}
+
+// ExecWithOptions runs a command configured by opts and waits for its completion.
+// Returns its complete stdout and stderr outputs and an error, if any (including timeout).
+func (pm *Manager) ExecWithOptions(desc, cmdName string, args []string, opts ...RunOption) (string, string, error) {
+	r, err := pm.ExecResult(desc, cmdName, args, opts...)
+	return r.Stdout, r.Stderr, err
+}
+
+// CleanedStderr waits for the process to exit and returns its stderr as cleaned by
+// the function given to WithStderrCleaner, or an empty string without that option.
+func (h *Handle) CleanedStderr() string {
+	<-h.done
+	return h.cleanedStderr
+}
+
+// PID returns the manager PID of the process.
+func (h *Handle) PID() int64 {
+	return h.pid
+}
+
+// Done returns a channel that is closed once the process has exited and been removed.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait waits for the process to exit and returns its complete stdout and stderr
+// outputs and an error, if any (including timeout). It may be called several times.
+func (h *Handle) Wait() (string, string, error) {
+	if stack := h.pm.callerStack(); stack != "" {
+		h.pm.mutex.Lock()
+		h.proc.stack = stack
+		h.pm.mutex.Unlock()
+	}
+	<-h.done
+	return h.stdout, h.stderr, h.err
+}
+
+func (pm *Manager) start(desc, cmdName string, args []string, opts []RunOption) (*Handle, error) {
+	o := defaultRunOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return pm.startWithOptions(desc, cmdName, args, o)
+}
+
+// startWithOptions is start with the options already applied, sparing the
+// allocation of the RunOption closures for the most frequent commands.
+func (pm *Manager) startWithOptions(desc, cmdName string, args []string, o runOptions) (*Handle, error) {
+	if err := pm.admit(); err != nil {
+		return nil, err
+	}
+	if err := pm.spawnRate.allow(desc, pm.timeNow()); err != nil {
+		return nil, err
+	}
+	if o.err != nil {
+		return nil, o.err
+	}
+	var diagnostic *diagnosticRun
+	if o.diagnosticTransform != nil && o.stdin == nil && o.stdinArgs == nil && !o.inheritStdio {
+		diagnostic = &diagnosticRun{cmdName: cmdName, args: args, o: o}
+	}
+	if o.timeout == -1 {
+		o.timeout = pm.defaultTimeout(o.category)
+	}
+	if o.stdinArgs != nil {
+		var err error
+		if args, err = o.applyStdinArgs(cmdName, args); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.rlimits) > 0 && !rlimitsSupported {
+		return nil, ErrUnsupported
+	}
+
+	var stdinCleanup func()
+	if o.bufferStdin && o.stdin != nil {
+		var err error
+		if o.stdin, stdinCleanup, err = bufferStdin(o.stdin, bufferedStdinMemoryLimit); err != nil {
+			return nil, fmt.Errorf("failed to buffer stdin: %v", err)
+		}
+	}
+
+	// The slot is taken before the timeout starts, so that waiting for it doesn't count.
+	enqueuedAt := pm.timeNow()
+	parent := o.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if err := pm.limiter.acquire(parent, o.category); err != nil {
+		if stdinCleanup != nil {
+			stdinCleanup()
+		}
+		return nil, err
+	}
+	// The breaker is checked once the slot is taken, so that a probe doesn't wait for one,
+	// and it must be told the outcome of the command from then on.
+	breakerKey, err := pm.breaker.allow(o.category, desc, pm.timeNow())
+	if err != nil {
+		pm.limiter.release(o.category)
+		if stdinCleanup != nil {
+			stdinCleanup()
+		}
+		return nil, err
+	}
+
+	h := &Handle{
+		pm: pm,
+		proc: &Process{
+			Description: desc,
+			EnqueuedAt:  enqueuedAt,
+			RequestID:   o.requestID,
+			Category:    o.category,
+			Key:         o.key,
+			Attempt:     o.attempt,
+			Argv:        append([]string{cmdName}, args...),
+			managed:     true,
+
+			reaperExempt:   o.reaperExempt,
+			watchdogExempt: o.watchdogExempt,
+			privileges:     o.privileges(),
+		},
+		stdoutBuf:     o.stdoutBuffer,
+		stderrBuf:     o.stderrBuffer,
+		callerStdout:  o.stdoutBuffer != nil,
+		callerStderr:  o.stderrBuffer != nil,
+		done:          make(chan struct{}),
+		stderrCleaner: o.stderrCleaner,
+		failOnStderr:  o.failOnStderr,
+		onFinish:      o.onFinish,
+		onExit:        o.onExit,
+		diagnostic:    diagnostic,
+		stdinCleanup:  stdinCleanup,
+		breakerKey:    breakerKey,
+		drainDelay:    o.drainDelay,
+	}
+	if h.stdoutBuf == nil {
+		h.stdoutBuf = getBuffer()
+	}
+	if h.stderrBuf == nil {
+		h.stderrBuf = getBuffer()
+	}
+	if o.absoluteDeadline.IsZero() {
+		h.ctx, h.cancel = context.WithCancel(parent)
+	} else {
+		h.ctx, h.cancel = context.WithDeadline(parent, o.absoluteDeadline)
+	}
+	h.dl = newDeadline(o.timeout, o.maxExtension, h.cancel)
+	h.dl.ctxDeadline, _ = h.ctx.Deadline()
+	h.proc.deadline = h.dl
+
+	cmd := pm.command(h.ctx, cmdName, args...)
+	cmd.Dir = o.dir
+	cmd.Env = o.env
+	if err := o.configure(cmd); err != nil {
+		pm.breaker.record(breakerKey, true, pm.timeNow())
+		pm.limiter.release(o.category)
+		h.release()
+		return nil, err
+	}
+	h.budget.pm = pm
+	if o.inheritStdio {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if o.stdin == nil {
+			cmd.Stdin = os.Stdin
+		}
+	} else {
+		if o.stdout != nil {
+			var w io.Writer = o.stdout
+			if o.linePrefix != "" {
+				w = &prefixWriter{w: w, prefix: []byte(o.linePrefix)}
+			}
+			if o.normalizeNewlines {
+				nw := &newlineWriter{w: w}
+				h.flushers = append(h.flushers, nw)
+				w = nw
+			}
+			cmd.Stdout = &countingWriter{w: w, count: &h.proc.stdoutBytes}
+		} else {
+			cmd.Stdout = h.outputWriter(&h.stdoutWriter, h.stdoutBuf, &h.proc.stdoutBytes, &o)
+		}
+		if !o.stderrToStdout {
+			cmd.Stderr = h.outputWriter(&h.stderrWriter, h.stderrBuf, &h.proc.stderrBytes, &o)
+		}
+		if o.progress != nil {
+			pw := &progressWriter{parse: o.progressParser, fn: o.progress}
+			if o.stderrToStdout {
+				cmd.Stdout = io.MultiWriter(cmd.Stdout, pw)
+			} else {
+				cmd.Stderr = io.MultiWriter(cmd.Stderr, pw)
+			}
+		}
+		if o.heartbeat > 0 {
+			cmd.Stdout = &heartbeatWriter{w: cmd.Stdout, deadline: h.dl, d: o.heartbeat}
+			if cmd.Stderr != nil {
+				cmd.Stderr = &heartbeatWriter{w: cmd.Stderr, deadline: h.dl, d: o.heartbeat}
+			}
+		}
+		stdout, err := h.pipeOutput(cmd.Stdout)
+		if err != nil {
+			pm.breaker.record(breakerKey, true, pm.timeNow())
+			pm.limiter.release(o.category)
+			h.release()
+			return nil, err
+		}
+		cmd.Stdout = stdout
+		if o.stderrToStdout {
+			// Both outputs are copied from a single pipe, in order.
+			cmd.Stderr = stdout
+		} else if cmd.Stderr, err = h.pipeOutput(cmd.Stderr); err != nil {
+			h.closeWriters()
+			pm.breaker.record(breakerKey, true, pm.timeNow())
+			pm.limiter.release(o.category)
+			h.release()
+			return nil, err
+		}
+	}
+	h.cmd = cmd
+
+	// stdin is fed through a pipe owned by the manager so that CloseStdin can
+	// close it while the command is still running.
+	var stdinPipe io.WriteCloser
+	if o.stdin != nil {
+		var err error
+		if stdinPipe, err = cmd.StdinPipe(); err != nil {
+			h.closeWriters()
+			pm.breaker.record(breakerKey, true, pm.timeNow())
+			pm.limiter.release(o.category)
+			h.release()
+			return nil, err
+		}
+	}
+
+	h.span = pm.startSpan(desc, cmd)
+	h.start = time.Now()
+	err = startWithRlimits(cmd, o.rlimits)
+	h.closeWriters()
+	if err != nil {
+		pm.breaker.record(breakerKey, true, pm.timeNow())
+		h.drain(false)
+		pm.limiter.release(o.category)
+		endSpan(h.span, cmd, h.start, err)
+		h.release()
+		return nil, err
+	}
+
+	h.proc.Cmd = cmd
+	h.proc.stdin = stdinPipe
+	if o.recordEnv {
+		h.proc.Env = pm.redactEnv(cmd.Env)
+	}
+	h.pid = pm.add(h.proc)
+	if o.startupTimeout > 0 && !o.inheritStdio {
+		h.st = newStartupTimeout(o.startupTimeout, h.proc, h.cancel)
+	}
+	if o.pidFile != "" {
+		h.writePidFile(o.pidFile)
+	}
+	if o.afterStart != nil {
+		o.afterStart(h.proc)
+	}
+	if stdinPipe != nil {
+		h.stdinDone = make(chan struct{})
+		go h.copyStdin(stdinPipe, o.stdin, o.stdinLimit)
+	}
+	return h, nil
+}
+
+// wait waits for the command, removes it from the manager and closes h.done.
+// It must be called exactly once per started handle.
+func (h *Handle) wait() {
+	defer close(h.done)
+
+	// The outputs are copied from pipes owned by the handle, except with WithInheritStdio:
+	// everything written before exiting has gone through the writers once drained.
+	// They must never be read through StdoutPipe or StderrPipe, which Wait closes.
+	err := h.cmd.Wait()
+	if h.onExit != nil {
+		h.onExit()
+	}
+	// A killed command may leave children holding its outputs open.
+	killed := h.ctx.Err() != nil || atomic.LoadInt32(&h.proc.killed) == 1 ||
+		h.cmd.ProcessState != nil && !h.cmd.ProcessState.Exited()
+	h.drain(killed)
+	for _, f := range h.flushers {
+		_ = f.Flush()
+	}
+	if err == nil && h.failOnStderr && atomic.LoadInt64(&h.proc.stderrBytes) > 0 {
+		err = ErrUnexpectedStderr
+	}
+	h.pm.limiter.release(h.proc.Category)
+	ctxErr := h.ctx.Err()
+	if h.dl.exceeded() {
+		ctxErr = context.DeadlineExceeded
+	} else if h.st != nil && h.st.exceeded() {
+		ctxErr = ErrStartupTimeout
+	}
+	h.duration = time.Since(h.start)
+	h.state = StateExited
+	switch {
+	case ctxErr == context.DeadlineExceeded || ctxErr == ErrStartupTimeout:
+		h.state = StateTimedOut
+	case ctxErr == context.Canceled:
+		h.state = StateCanceled
+	case killed:
+		h.state = StateKilled
+	}
+	h.killReason = h.reason(ctxErr)
+	if h.stdinDone != nil {
+		// Wait closes the pipe, so the copy is bound to finish once stdin stops blocking.
+		<-h.stdinDone
+		if err == nil {
+			err = h.stdinErr
+		}
+	}
+	if atomic.LoadInt32(&h.stdinExceeded) == 1 {
+		err = ErrStdinLimitExceeded
+	}
+
+	// The outputs are copied out so that the buffers can go back to the pool, except
+	// those of the caller, which are only copied for the stderr cleaner and the error.
+	var callerStdout, callerStderr *bytes.Buffer
+	if h.callerStdout {
+		callerStdout = h.stdoutBuf
+	} else {
+		h.stdout = h.stdoutBuf.String()
+	}
+	if h.callerStderr {
+		callerStderr = h.stderrBuf
+	} else {
+		h.stderr = h.stderrBuf.String()
+	}
+	h.release()
+	if h.stderrCleaner != nil {
+		if callerStderr != nil {
+			h.cleanedStderr = h.stderrCleaner(callerStderr.String())
+		} else {
+			h.cleanedStderr = h.stderrCleaner(h.stderr)
+		}
+	}
+	if err != nil {
+		switch ctxErr {
+		case context.DeadlineExceeded, ErrStartupTimeout:
+			atomic.AddInt64(&h.pm.stats.timedOut, 1)
+		case context.Canceled:
+			atomic.AddInt64(&h.pm.stats.canceled, 1)
+		}
+		h.exitCode = exitCode(h.cmd.ProcessState)
+		var signal syscall.Signal
+		var signaled bool
+		if h.cmd.ProcessState != nil {
+			signal, signaled = exitSignal(h.cmd.ProcessState)
+		}
+		execErr := &ExecError{
+			PID:           h.pid,
+			Description:   h.proc.CurrentDescription(),
+			RequestID:     h.proc.RequestID,
+			InstanceID:    h.proc.InstanceID,
+			ExitCode:      h.exitCode,
+			Duration:      h.duration,
+			Signaled:      signaled,
+			Signal:        signal,
+			Cause:         err,
+			Stdout:        h.stdout,
+			Stderr:        h.stderr,
+			CleanedStderr: h.cleanedStderr,
+			formattedPID:  h.pm.FormatPID(h.pid),
+			ctxErr:        ctxErr,
+			OOMKilled:     h.oomKilled(ctxErr),
+			Reason:        h.killReason,
+		}
+		if callerStdout != nil {
+			execErr.Stdout = callerStdout.String()
+		}
+		if callerStderr != nil {
+			execErr.Stderr = callerStderr.String()
+		}
+		if h.diagnostic != nil && ctxErr != context.Canceled && atomic.LoadInt32(&h.proc.killed) == 0 {
+			execErr.DiagnosticOutput = h.diagnose()
+		}
+		h.err = execErr
+	}
+	failed := h.err != nil && ctxErr != context.Canceled && atomic.LoadInt32(&h.proc.killed) == 0
+	h.pm.breaker.record(h.breakerKey, failed, h.pm.timeNow())
+	for _, onFinish := range h.onFinish {
+		onFinish(h.stdout, h.stderr, h.err)
+	}
+
+	h.pm.Remove(h.pid)
+	if h.pidFile != "" {
+		if err := os.Remove(h.pidFile); err != nil {
+			log.Warn("Unable to remove the pidfile of process %s: %v", h.pm.FormatPID(h.pid), err)
+		}
+	}
+	h.budget.release()
+	endSpan(h.span, h.cmd, h.start, err)
+	h.recordHistory(err)
+}
+
+// writePidFile writes the OS PID of the process to path. A failure is only
+// logged: the process runs without its pidfile rather than being killed.
+func (h *Handle) writePidFile(path string) {
+	pid := strconv.Itoa(h.cmd.Process.Pid) + "\n"
+	if err := ioutil.WriteFile(path, []byte(pid), 0644); err != nil {
+		log.Warn("Unable to write the pidfile of process %s: %v", h.pm.FormatPID(h.pid), err)
+		return
+	}
+	h.pidFile = path
+}
+
+// oomKilled guesses whether the process was killed by the OOM killer rather than by us.
+func (h *Handle) oomKilled(ctxErr error) bool {
+	return h.cmd.ProcessState != nil && killedBySIGKILL(h.cmd.ProcessState) &&
+		ctxErr == nil && atomic.LoadInt32(&h.proc.killed) == 0
+}
+
+// outputWriter sets up cw, embedded in the handle to spare an allocation, as the
+// writer capturing an output of the command into buf, counting the bytes written
+// by the command into count. Without an output budget, buf is written directly.
+func (h *Handle) outputWriter(cw *countingWriter, buf io.Writer, count *int64, o *runOptions) io.Writer {
+	w := buf
+	if atomic.LoadInt64(&h.pm.outputBudget) > 0 {
+		w = h.budget.writer(buf)
+	}
+	if o.normalizeNewlines {
+		nw := &newlineWriter{w: w}
+		h.flushers = append(h.flushers, nw)
+		w = nw
+	}
+	cw.w, cw.count = w, count
+	return cw
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w     io.Writer
+	count *int64 // accessed atomically
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(cw.count, int64(len(p)))
+	return cw.w.Write(p)
+}
+
+// release stops the deadline, cancels the context of the handle and returns its buffers to the pool.
+func (h *Handle) release() {
+	h.dl.stop()
+	if h.st != nil {
+		h.st.timer.Stop()
+	}
+	h.cancel()
+	if h.stdinCleanup != nil {
+		h.stdinCleanup()
+	}
+	if !h.callerStdout {
+		putBuffer(h.stdoutBuf)
+	}
+	if !h.callerStderr {
+		putBuffer(h.stderrBuf)
+	}
+	h.stdoutBuf, h.stderrBuf = nil, nil
+}
+
+// recordHistory records the finished process with the error returned by cmd.Wait,
+// rather than the ExecError which holds the possibly large outputs.
+func (h *Handle) recordHistory(err error) {
+	entry := h.proc.historyEntry(h.pm)
+	entry.Reason = h.killReason
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	h.pm.mutex.Lock()
+	h.pm.recordHistoryLocked(entry)
+	h.pm.mutex.Unlock()
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_Start(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	h, err := pm.Start("Start", "git", []string{"--version"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), h.PID())
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done was not closed")
+	}
+	assert.Equal(t, 0, pm.Count())
+
+	stdout, _, err := h.Wait()
+	assert.NoError(t, err)
+	assert.Contains(t, stdout, "git version")
+
+	// Wait can be called again and returns the same result
+	stdout2, _, err := h.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, stdout, stdout2)
+
+	_, err = pm.Start("StartFail", "/no/such/binary", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 0, pm.Count())
+}
+
+func TestManager_StartWithLifecycleContext(t *testing.T) {
+	pm := newFakeManager()
+
+	ctx, pid, err := pm.StartWithLifecycleContext(context.Background(), "Echo", "echo", []string{"hello"})
+	assert.NoError(t, err)
+	assert.NotZero(t, pid)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context was not canceled when the process exited")
+	}
+	assert.Equal(t, 0, pm.Count())
+
+	ctx, pid, err = pm.StartWithLifecycleContext(context.Background(), "Hang", "hang", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ctx.Err(), "the context was canceled while the process runs")
+	assert.NoError(t, pm.Kill(pid))
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context was not canceled when the process was killed")
+	}
+
+	// Canceling the parent kills the process
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, _, err = pm.StartWithLifecycleContext(parent, "Hang", "hang", nil)
+	assert.NoError(t, err)
+	cancel()
+	<-ctx.Done()
+	eventually(t, func() bool {
+		return pm.Count() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestManager_StartAbandoned(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 200; i++ {
+		_, err := pm.Start("Abandoned", "git", []string{"--version"}, WithTimeout(5*time.Second))
+		assert.NoError(t, err)
+	}
+
+	eventually(t, func() bool {
+		return pm.Count() == 0
+	}, 10*time.Second, 10*time.Millisecond, "abandoned processes were not removed")
+	eventually(t, func() bool {
+		return runtime.NumGoroutine() <= before+2
+	}, 10*time.Second, 10*time.Millisecond, "goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+}
+
+func TestManager_ExecCompleteOutput(t *testing.T) {
+	pm := newFakeManager()
+
+	for name, opts := range map[string][]RunOption{
+		"Capture":           nil,
+		"NormalizeNewlines": {WithNormalizeNewlines()},
+		"Heartbeat":         {WithHeartbeat(time.Second)},
+		"CleanStderr":       {WithStderrCleaner(nil)},
+	} {
+		// The last line is written right before exiting
+		stdout, _, err := pm.ExecWithOptions(name, "lines", []string{"5000", "0"}, opts...)
+		assert.NoError(t, err, name)
+		assert.True(t, strings.HasSuffix(stdout, "\nline 5000\n"), "%s lost the end of the output", name)
+	}
+
+	var streamed bytes.Buffer
+	stdout, _, err := pm.ExecWithOptions("Stream", "lines", []string{"5000", "0"}, WithStdoutWriter(&streamed))
+	assert.NoError(t, err)
+	assert.Empty(t, stdout)
+	assert.True(t, strings.HasSuffix(streamed.String(), "\nline 5000\n"), "the end of the streamed output was lost")
+}
+
+func TestManager_ExecStdoutClosedEarly(t *testing.T) {
+	pm := newFakeManager()
+
+	// The end of stdout must neither end the command nor the copy of stderr
+	for name, opts := range map[string][]RunOption{
+		"Capture": nil,
+		"Stream":  {WithStdoutWriter(&bytes.Buffer{})},
+	} {
+		h, err := pm.Start(name, "closeout", []string{"100000", "500"}, opts...)
+		assert.NoError(t, err)
+		start := time.Now()
+		_, stderr, err := h.Wait()
+		assert.NoError(t, err, name)
+		assert.True(t, time.Since(start) >= 400*time.Millisecond, "%s returned before the command exited", name)
+		assert.Equal(t, 100000, len(stderr), "%s lost stderr", name)
+		if opts == nil {
+			stdout, _, _ := h.Wait()
+			assert.Equal(t, "out", stdout)
+		}
+	}
+}
+
+func TestWithPostExitDrainDelay(t *testing.T) {
+	pm := newFakeManager()
+
+	// The late write of the child comes within the delay, which then stops with the child
+	start := time.Now()
+	_, stderr, err := pm.ExecWithOptions("Hook", "latewrite", []string{"100", "late"}, WithPostExitDrainDelay(10*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, "late", stderr)
+	assert.True(t, time.Since(start) < 8*time.Second)
+
+	// The child holding stderr open is not waited for past the delay
+	start = time.Now()
+	_, stderr, err = pm.ExecWithOptions("Hook", "latewrite", []string{"5000", "too late"}, WithPostExitDrainDelay(300*time.Millisecond))
+	assert.NoError(t, err)
+	assert.Empty(t, stderr)
+	assert.True(t, time.Since(start) < 8*time.Second)
+}
+
+func BenchmarkExecSmallOutput(b *testing.B) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := pm.Exec("Benchmark", "git", "--version"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkExecSmallOutputBudget is BenchmarkExecSmallOutput without the fast path
+// of the most frequent commands, for comparison.
+func BenchmarkExecSmallOutputBudget(b *testing.B) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	pm.SetOutputBudget(1 << 30)
+	opts := []RunOption{WithTimeout(-1), WithDir(""), WithEnv(nil), WithStdin(nil)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := pm.ExecWithOptions("Benchmark", "git", []string{"--version"}, opts...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestManager_ExecPartialOutput(t *testing.T) {
+	pm := newFakeManager()
+
+	// The child of the command keeps its outputs open after it is killed
+	start := time.Now()
+	stdout, _, err := pm.ExecTimeout(2*time.Second, "Stall", "stall", "3")
+	assert.True(t, time.Since(start) < 6*time.Second, "the outputs were drained until the child exited")
+	assert.True(t, errors.Is(err, ErrExecTimeout))
+	assert.Equal(t, "line 1\nline 2\nline 3\n", stdout)
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, stdout, execErr.Stdout)
+	}
+}
+
+func TestManager_ExecOK(t *testing.T) {
+	pm := newFakeManager()
+
+	assert.True(t, pm.ExecOK("Echo", "echo", "hello"))
+	assert.False(t, pm.ExecOK("Fail", "fail", "1", "boom"))
+	assert.False(t, pm.ExecOK("Unknown", "unknown"))
+	assert.Equal(t, 0, pm.Count())
+
+	done := make(chan bool)
+	go func() {
+		done <- pm.ExecOK("Hang", "hang")
+	}()
+	eventually(t, func() bool {
+		return pm.Count() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, pm.KillAll())
+	assert.False(t, <-done)
+
+	assert.NoError(t, pm.Close())
+	assert.False(t, pm.ExecOK("Echo", "echo", "hello"))
+}
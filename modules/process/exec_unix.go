@@ -0,0 +1,96 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxCommandLineLength is a conservative limit for the total length of the
+// arguments of a command, well below ARG_MAX and the per-argument limit of Linux.
+const maxCommandLineLength = 128 * 1024
+
+// killedBySIGKILL reports whether the process died from SIGKILL.
+func killedBySIGKILL(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGKILL
+}
+
+// exitCode returns the exit code of the process, or -1 if it has not exited
+// or was terminated by a signal.
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return -1
+	}
+	return status.ExitStatus()
+}
+
+// isBrokenPipe reports whether err comes from writing to a pipe without reader.
+func isBrokenPipe(err error) bool {
+	return err == syscall.EPIPE
+}
+
+// exitSignal returns the signal which terminated the process, if any.
+func exitSignal(state *os.ProcessState) (syscall.Signal, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return status.Signal(), true
+}
+
+// signalName returns the name of sig, e.g. SIGSEGV.
+func signalName(sig syscall.Signal) string {
+	if name := unix.SignalName(sig); name != "" {
+		return name
+	}
+	return sig.String()
+}
+
+// systemPathDirs returns the directories of the system binaries.
+func systemPathDirs() []string {
+	return []string{"/usr/bin", "/bin"}
+}
+
+func sysProcAttr(cmd *exec.Cmd) *syscall.SysProcAttr {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return cmd.SysProcAttr
+}
+
+func setChroot(cmd *exec.Cmd, dir string) error {
+	sysProcAttr(cmd).Chroot = dir
+	return nil
+}
+
+func setCredential(cmd *exec.Cmd, cred *Credential) error {
+	euid, egid := os.Geteuid(), os.Getegid()
+	if euid != 0 && (int(cred.UID) != euid || int(cred.GID) != egid) {
+		return fmt.Errorf("insufficient privilege to run as %d:%d, Gitea is running as %d:%d and not as root", cred.UID, cred.GID, euid, egid)
+	}
+	if euid != 0 && len(cred.Groups) > 0 {
+		return fmt.Errorf("insufficient privilege to set the supplementary groups, Gitea is running as %d:%d and not as root", euid, egid)
+	}
+	sysProcAttr(cmd).Credential = &syscall.Credential{
+		Uid:    cred.UID,
+		Gid:    cred.GID,
+		Groups: cred.Groups,
+		// Only root may call setgroups, which would make the fork fail otherwise.
+		NoSetGroups: euid != 0,
+	}
+	return nil
+}
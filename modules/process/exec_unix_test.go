@@ -0,0 +1,137 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecError_OOMKilled(t *testing.T) {
+	pm := NewManager()
+
+	// A SIGKILL from outside the manager, as the OOM killer would send
+	_, _, err := pm.Exec("SelfKill", "sh", "-c", "kill -9 $$")
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.True(t, execErr.OOMKilled)
+	}
+
+	_, _, err = pm.ExecTimeout(100*time.Millisecond, "Timeout", "sleep", "5")
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.False(t, execErr.OOMKilled, "a timeout is not an OOM kill")
+	}
+
+	h, err := pm.Start("Killed", "sleep", []string{"5"})
+	assert.NoError(t, err)
+	assert.NoError(t, pm.Kill(h.PID()))
+	_, _, err = h.Wait()
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.False(t, execErr.OOMKilled, "a kill by the manager is not an OOM kill")
+	}
+
+	_, _, err = pm.Exec("Fail", "sh", "-c", "exit 1")
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.False(t, execErr.OOMKilled)
+	}
+}
+
+func TestWithChroot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chroot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var o runOptions
+	WithChroot(dir)(&o)
+	assert.NoError(t, o.err)
+	cmd := exec.Command("git", "--version")
+	assert.NoError(t, o.configure(cmd))
+	if assert.NotNil(t, cmd.SysProcAttr) {
+		assert.Equal(t, dir, cmd.SysProcAttr.Chroot)
+	}
+
+	o = runOptions{}
+	WithChroot(filepath.Join(dir, "missing"))(&o)
+	assert.Error(t, o.err)
+
+	pm := NewManager()
+	_, _, err = pm.ExecWithOptions("Chroot", "git", []string{"--version"}, WithChroot(filepath.Join(dir, "missing")))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid chroot")
+}
+
+func TestWithCredential(t *testing.T) {
+	uid, gid := uint32(os.Geteuid()), uint32(os.Getegid())
+
+	// Only root may set the supplementary groups
+	var groups []uint32
+	if uid == 0 {
+		groups = []uint32{gid}
+	}
+	var o runOptions
+	WithCredential(uid, gid, groups)(&o)
+	cmd := exec.Command("id")
+	assert.NoError(t, o.configure(cmd))
+	if assert.NotNil(t, cmd.SysProcAttr) && assert.NotNil(t, cmd.SysProcAttr.Credential) {
+		assert.Equal(t, uid, cmd.SysProcAttr.Credential.Uid)
+		assert.Equal(t, gid, cmd.SysProcAttr.Credential.Gid)
+		assert.Equal(t, groups, cmd.SysProcAttr.Credential.Groups)
+		assert.Equal(t, uid != 0, cmd.SysProcAttr.Credential.NoSetGroups)
+	}
+
+	pm := Manager{Processes: make(map[int64]*Process)}
+	stdout, _, err := pm.ExecWithOptions("Credential", "sh", []string{"-c", "id -u; id -g"}, WithCredential(uid, gid, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\n%d\n", uid, gid), stdout)
+
+	if os.Geteuid() == 0 {
+		stdout, _, err := pm.ExecWithOptions("Credential", "sh", []string{"-c", "id -u; id -g; id -G"}, WithCredential(65534, 65534, []uint32{65534}))
+		assert.NoError(t, err)
+		assert.Equal(t, "65534\n65534\n65534\n", stdout)
+		return
+	}
+
+	o = runOptions{}
+	WithCredential(uid+1, gid, nil)(&o)
+	err = o.configure(exec.Command("id"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "insufficient privilege")
+	}
+	o = runOptions{}
+	WithCredential(uid, gid, []uint32{gid})(&o)
+	err = o.configure(exec.Command("id"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "insufficient privilege")
+	}
+}
+
+func TestExecError_Signal(t *testing.T) {
+	pm := newFakeManager()
+
+	_, _, err := pm.Exec("Signal", "signal", "15")
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.True(t, execErr.Signaled)
+		assert.Equal(t, syscall.SIGTERM, execErr.Signal)
+		assert.Contains(t, err.Error(), "terminated by signal SIGTERM (15)")
+	}
+
+	_, _, err = pm.Exec("Fail", "fail", "3", "boom")
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.False(t, execErr.Signaled)
+		assert.NotContains(t, err.Error(), "terminated by signal")
+	}
+}
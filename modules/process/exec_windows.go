@@ -0,0 +1,63 @@
+// +build windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// maxCommandLineLength is a conservative limit for the total length of the
+// command line, which Windows caps at 32767 characters.
+const maxCommandLineLength = 32000
+
+// killedBySIGKILL is always false as there are no signals on Windows.
+func killedBySIGKILL(state *os.ProcessState) bool {
+	return false
+}
+
+// exitCode returns the exit code of the process, or -1 if it has not exited.
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return -1
+	}
+	return status.ExitStatus()
+}
+
+// isBrokenPipe reports whether err comes from writing to a pipe without reader.
+func isBrokenPipe(err error) bool {
+	const errorNoData = syscall.Errno(232)
+	return err == syscall.ERROR_BROKEN_PIPE || err == errorNoData
+}
+
+// exitSignal always reports no signal as there are no signals on Windows.
+func exitSignal(state *os.ProcessState) (syscall.Signal, bool) {
+	return 0, false
+}
+
+func signalName(sig syscall.Signal) string {
+	return sig.String()
+}
+
+// systemPathDirs returns the directories of the system binaries.
+func systemPathDirs() []string {
+	return []string{filepath.Join(os.Getenv("SystemRoot"), "System32")}
+}
+
+func setChroot(cmd *exec.Cmd, dir string) error {
+	return ErrUnsupported
+}
+
+func setCredential(cmd *exec.Cmd, cred *Credential) error {
+	return ErrUnsupported
+}
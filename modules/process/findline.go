@@ -0,0 +1,69 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"context"
+)
+
+// ExecFindLine runs a command with the default timeout and returns the first line of its
+// stdout, without the line ending, for which match returns true, e.g. the one starting with
+// "commit " in a large log. The command is killed as soon as the line is found, so that the
+// rest of its output isn't waited for, and its error is then ignored. The bool reports
+// whether such a line was found.
+func (pm *Manager) ExecFindLine(match func(string) bool, desc, cmdName string, args ...string) (string, bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fw := &findLineWriter{match: match, cancel: cancel}
+	_, _, err := pm.ExecWithOptions(desc, cmdName, args, WithContext(ctx), WithStdoutWriter(fw))
+	if !fw.found && fw.buf.Len() > 0 {
+		// The last line had no line ending
+		fw.check(fw.buf.Bytes())
+	}
+	if fw.found {
+		return fw.line, true, nil
+	}
+	return "", false, err
+}
+
+// findLineWriter looks for the line of ExecFindLine and cancels the command once found.
+type findLineWriter struct {
+	match  func(string) bool
+	cancel context.CancelFunc
+
+	buf   bytes.Buffer // incomplete line
+	found bool
+	line  string
+}
+
+func (fw *findLineWriter) Write(p []byte) (int, error) {
+	if fw.found {
+		// Discard what comes until the command is killed
+		return len(p), nil
+	}
+	fw.buf.Write(p)
+	for !fw.found {
+		i := bytes.IndexByte(fw.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		fw.check(fw.buf.Next(i + 1)[:i])
+	}
+	if fw.found {
+		fw.buf.Reset()
+	}
+	return len(p), nil
+}
+
+func (fw *findLineWriter) check(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if fw.match(string(line)) {
+		fw.found = true
+		fw.line = string(line)
+		fw.cancel()
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_ExecFindLine(t *testing.T) {
+	pm := newFakeManager()
+
+	// The command would take 10s to print all of its lines
+	start := time.Now()
+	line, found, err := pm.ExecFindLine(func(line string) bool {
+		return strings.HasSuffix(line, " 3")
+	}, "Find", "lines", "1000", "10")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "line 3", line)
+	assert.True(t, time.Since(start) < 5*time.Second, "the command should have been killed once the line was found")
+	assert.Equal(t, 0, pm.Count())
+
+	line, found, err = pm.ExecFindLine(func(line string) bool {
+		return line == "missing"
+	}, "Find", "lines", "3", "0")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Empty(t, line)
+
+	_, found, err = pm.ExecFindLine(func(string) bool { return true }, "Find", "fail", "2", "boom")
+	assert.Error(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "time"
+
+// ProcessDescriptor describes a synthetic process for NewManagerWithProcesses.
+type ProcessDescriptor struct {
+	PID         int64 // 0 for the next free PID
+	Start       time.Time
+	Description string
+	Category    Category
+}
+
+// NewManagerWithProcesses is test-support API: it returns a manager tracking synthetic
+// processes which were never started, so that policies like OlderThan, CountByCategory
+// or the reaper can be exercised against a fixed population without forking. now is the
+// clock of the manager, time.Now if nil, e.g. to make the ages of the processes
+// deterministic. The processes can't be killed or signalled, which fails with
+// ErrNotStarted, but they can be removed. The PIDs of later processes follow the highest
+// given one.
+func NewManagerWithProcesses(now func() time.Time, procs []ProcessDescriptor) *Manager {
+	pm := &Manager{
+		Processes: make(map[int64]*Process, len(procs)),
+		now:       now,
+	}
+	pm.SetHistorySize(DefaultHistorySize)
+	for _, desc := range procs {
+		pid := desc.PID
+		if pid == 0 {
+			pid = pm.counter + 1
+		}
+		proc := &Process{
+			PID:         pid,
+			Description: desc.Description,
+			Start:       desc.Start,
+			EnqueuedAt:  desc.Start,
+			Category:    desc.Category,
+		}
+		pm.Processes[pid] = proc
+		if pid > pm.counter {
+			pm.counter = pid
+		}
+	}
+	return pm
+}
+
+// Clone is test-support API: it returns a manager, with the same clock, tracking
+// synthetic copies of the processes of pm as NewManagerWithProcesses does, e.g. to try
+// a policy on a snapshot of the live processes without affecting them.
+func (pm *Manager) Clone() *Manager {
+	snap := pm.Snapshot()
+	procs := make([]ProcessDescriptor, 0, len(snap.Processes))
+	for _, proc := range snap.Processes {
+		procs = append(procs, ProcessDescriptor{
+			PID:         proc.PID,
+			Start:       proc.Start,
+			Description: proc.CurrentDescription(),
+			Category:    proc.Category,
+		})
+	}
+	pm.mutex.Lock()
+	now, counter := pm.now, pm.counter
+	pm.mutex.Unlock()
+	clone := NewManagerWithProcesses(now, procs)
+	clone.Name = pm.Name
+	if counter > clone.counter {
+		clone.counter = counter
+	}
+	return clone
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewManagerWithProcesses(t *testing.T) {
+	now := time.Date(2019, 12, 1, 12, 0, 0, 0, time.UTC)
+	pm := NewManagerWithProcesses(func() time.Time { return now }, []ProcessDescriptor{
+		{PID: 3, Start: now.Add(-time.Hour), Description: "stuck sync", Category: CategoryMirror},
+		{PID: 7, Start: now.Add(-time.Minute), Description: "recent sync", Category: CategoryMirror},
+		{Start: now.Add(-2 * time.Hour), Description: "stuck blame", Category: CategoryGit},
+	})
+
+	assert.Equal(t, 3, pm.Count())
+	assert.Equal(t, map[Category]int{CategoryMirror: 2, CategoryGit: 1}, pm.CountByCategory())
+	older := pm.OlderThan(30 * time.Minute)
+	if assert.Len(t, older, 2) {
+		assert.Equal(t, "stuck blame", older[0].CurrentDescription())
+		assert.Equal(t, int64(8), older[0].PID, "the next PID follows the highest one")
+		assert.Equal(t, "stuck sync", older[1].CurrentDescription())
+	}
+	infos := pm.ProcessesCopy()
+	if assert.Len(t, infos, 3) {
+		assert.Equal(t, time.Hour, infos[0].Elapsed)
+	}
+
+	// Policies run without killing anything
+	pm.reap(30 * time.Minute)
+	killed, errs := pm.KillOlderThanByCategory(30*time.Minute, CategoryMirror)
+	assert.Empty(t, killed)
+	assert.Empty(t, errs)
+	assert.Equal(t, ErrNotStarted, pm.Kill(3))
+	assert.Equal(t, 3, pm.Count())
+
+	assert.Equal(t, int64(9), pm.Add("added", exec.Command("foo")))
+	pm.Remove(3)
+	assert.Equal(t, 3, pm.Count())
+}
+
+func TestManager_Clone(t *testing.T) {
+	pm := Manager{Name: "web", Processes: make(map[int64]*Process)}
+	first := pm.Add("first", exec.Command("foo"))
+	pm.Remove(pm.Add("gone", exec.Command("foo")))
+	pm.Processes[first].Category = CategoryMirror
+
+	clone := pm.Clone()
+	assert.Equal(t, "web", clone.Name)
+	if infos := clone.ProcessesCopy(); assert.Len(t, infos, 1) {
+		assert.Equal(t, first, infos[0].PID)
+		assert.Equal(t, "first", infos[0].Description)
+		assert.Equal(t, CategoryMirror, infos[0].Category)
+	}
+	assert.Equal(t, int64(3), clone.Add("third", exec.Command("foo")), "PIDs are not reused")
+
+	clone.Remove(first)
+	assert.Equal(t, 1, pm.Count(), "the original is left alone")
+}
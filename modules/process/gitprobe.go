@@ -0,0 +1,83 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GitInfo describes a git binary, see ProbeGit.
+type GitInfo struct {
+	// Version is the version of git as major.minor.patch, e.g. "2.24.1".
+	Version string
+	// Raw is the output of git --version, e.g. "git version 2.24.1.windows.2".
+	Raw string
+
+	major, minor, patch int
+}
+
+// AtLeast reports whether the version of git is at least major.minor.patch, e.g. to
+// only use an option once git supports it.
+func (i GitInfo) AtLeast(major, minor, patch int) bool {
+	if i.major != major {
+		return i.major > major
+	}
+	if i.minor != minor {
+		return i.minor > minor
+	}
+	return i.patch >= patch
+}
+
+var gitVersionPattern = regexp.MustCompile(`^git version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseGitVersion parses the output of git --version.
+func parseGitVersion(raw string) (GitInfo, error) {
+	info := GitInfo{Raw: strings.TrimSpace(raw)}
+	m := gitVersionPattern.FindStringSubmatch(info.Raw)
+	if m == nil {
+		return GitInfo{}, fmt.Errorf("unable to parse the git version: %q", info.Raw)
+	}
+	info.major, _ = strconv.Atoi(m[1])
+	info.minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		info.patch, _ = strconv.Atoi(m[3])
+	}
+	info.Version = fmt.Sprintf("%d.%d.%d", info.major, info.minor, info.patch)
+	return info, nil
+}
+
+// ProbeGit runs git --version with the git binary at gitPath, "git" if empty, and returns
+// its version, so that callers can gate features on the capabilities of git. The version
+// is cached by path, so only the first successful call runs git.
+func (pm *Manager) ProbeGit(gitPath string) (GitInfo, error) {
+	if gitPath == "" {
+		gitPath = "git"
+	}
+	pm.mutex.Lock()
+	info, ok := pm.gitInfos[gitPath]
+	pm.mutex.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	stdout, _, err := pm.Exec("ProbeGit", gitPath, "--version")
+	if err != nil {
+		return GitInfo{}, err
+	}
+	if info, err = parseGitVersion(stdout); err != nil {
+		return GitInfo{}, err
+	}
+
+	pm.mutex.Lock()
+	if pm.gitInfos == nil {
+		pm.gitInfos = make(map[string]GitInfo)
+	}
+	pm.gitInfos[gitPath] = info
+	pm.mutex.Unlock()
+	return info, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGitVersion(t *testing.T) {
+	for raw, version := range map[string]string{
+		"git version 2.24.1\n":                    "2.24.1",
+		"git version 2.20.1.windows.1":            "2.20.1",
+		"git version 2.21.0 (Apple Git-122.2)":    "2.21.0",
+		"git version 1.8.3.1":                     "1.8.3",
+		"git version 2.25.0.rc1":                  "2.25.0",
+		"git version 2.7.4-1ubuntu1":              "2.7.4",
+		"git version 2.17":                        "2.17.0",
+		"git version 2.24.0.390.g083378cc35-goog": "2.24.0",
+	} {
+		info, err := parseGitVersion(raw)
+		if assert.NoError(t, err, raw) {
+			assert.Equal(t, version, info.Version, raw)
+		}
+	}
+
+	for _, raw := range []string{"", "usage: git [--version]", "hub version 2.12.8"} {
+		_, err := parseGitVersion(raw)
+		assert.Error(t, err, raw)
+	}
+
+	info, _ := parseGitVersion("git version 2.20.1.windows.1")
+	assert.Equal(t, "git version 2.20.1.windows.1", info.Raw)
+	assert.True(t, info.AtLeast(2, 20, 1))
+	assert.True(t, info.AtLeast(1, 30, 0))
+	assert.True(t, info.AtLeast(2, 19, 9))
+	assert.False(t, info.AtLeast(2, 20, 2))
+	assert.False(t, info.AtLeast(3, 0, 0))
+}
+
+func TestManager_ProbeGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	pm := &Manager{Processes: make(map[int64]*Process)}
+	pm.SetHistorySize(10)
+
+	info, err := pm.ProbeGit("")
+	assert.NoError(t, err)
+	assert.True(t, info.AtLeast(1, 0, 0))
+	assert.Contains(t, info.Raw, "git version")
+
+	cached, err := pm.ProbeGit("git")
+	assert.NoError(t, err)
+	assert.Equal(t, info, cached)
+	assert.Len(t, pm.History(), 1, "the version should have been cached")
+
+	_, err = pm.ProbeGit("/nonexistent/git")
+	assert.Error(t, err)
+}
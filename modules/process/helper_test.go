@@ -0,0 +1,199 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeExecCommand is a CommandFactory that re-executes the test binary as a
+// helper process instead of running the real command, see TestHelperProcess.
+func fakeExecCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cs := append([]string{"-test.run=^TestHelperProcess$", "--", "helper-process", name}, args...)
+	return exec.CommandContext(ctx, os.Args[0], cs...)
+}
+
+// TestHelperProcess isn't a real test. It is run by fakeExecCommand and behaves
+// according to the faked command name: "echo ARGS..." prints its arguments,
+// "fail CODE MSG" prints MSG to stderr and exits with CODE, "spew N MS" prints N
+// bytes and sleeps MS milliseconds, "lines N MS" prints N lines every MS
+// milliseconds, "interleave N" alternately prints N lines to stdout and stderr,
+// "count [--stdin] ARGS..." prints the number of arguments or stdin lines,
+// "env NAMES..." prints NAME=value lines for the variables that are set, "pwd"
+// prints the working directory, "cat" copies its stdin to its stdout, "signal SIG"
+// dies from signal SIG, "ignoreterm" prints "ready" and sleeps ignoring SIGTERM,
+// "stall N" prints N lines and hangs along with a child sharing its outputs for
+// 10 seconds, "flaky FILE N" fails with exit code 3 until it is the Nth run counted
+// in FILE, "closeout N MS" prints "out", closes its stdout, sleeps MS milliseconds and
+// prints N bytes to stderr, "latewrite MS MSG" exits at once leaving a child which prints
+// MSG to its stderr after MS milliseconds, "nul ARGS..." prints its arguments each
+// followed by a NUL character, and "hang [MS]" sleeps.
+func TestHelperProcess(t *testing.T) {
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 3 || args[1] != "helper-process" {
+		return
+	}
+	name, args := args[2], args[3:]
+
+	switch name {
+	case "echo":
+		for i, arg := range args {
+			if i > 0 {
+				fmt.Print(" ")
+			}
+			fmt.Print(arg)
+		}
+	case "fail":
+		code, _ := strconv.Atoi(args[0])
+		fmt.Fprint(os.Stderr, args[1])
+		os.Exit(code)
+	case "signal":
+		// Dies from the signal, as long as the Go runtime lets it through
+		sig, _ := strconv.Atoi(args[0])
+		p, _ := os.FindProcess(os.Getpid())
+		_ = p.Signal(syscall.Signal(sig))
+		time.Sleep(5 * time.Second)
+	case "spew":
+		n, _ := strconv.Atoi(args[0])
+		ms, _ := strconv.Atoi(args[1])
+		fmt.Print(strings.Repeat("x", n))
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	case "lines":
+		n, _ := strconv.Atoi(args[0])
+		ms, _ := strconv.Atoi(args[1])
+		for i := 1; i <= n; i++ {
+			fmt.Printf("line %d\n", i)
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+		}
+	case "interleave":
+		n, _ := strconv.Atoi(args[0])
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(os.Stdout, "out %d\n", i)
+			fmt.Fprintf(os.Stderr, "err %d\n", i)
+		}
+	case "count":
+		if len(args) > 0 && args[0] == "--stdin" {
+			lines := 0
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				lines++
+			}
+			fmt.Print("stdin ", lines)
+		} else {
+			fmt.Print("args ", len(args))
+		}
+	case "env":
+		for _, name := range args {
+			if value, ok := os.LookupEnv(name); ok {
+				fmt.Printf("%s=%s\n", name, value)
+			}
+		}
+	case "cat":
+		_, _ = io.Copy(os.Stdout, os.Stdin)
+	case "pwd":
+		dir, _ := os.Getwd()
+		fmt.Print(dir)
+	case "ignoreterm":
+		signal.Ignore(syscall.SIGTERM)
+		fmt.Println("ready")
+		time.Sleep(time.Minute)
+	case "stall":
+		n, _ := strconv.Atoi(args[0])
+		for i := 1; i <= n; i++ {
+			fmt.Printf("line %d\n", i)
+		}
+		child := fakeExecCommand(context.Background(), "hang", "10000")
+		child.Stdout, child.Stderr = os.Stdout, os.Stderr
+		_ = child.Start()
+		time.Sleep(time.Minute)
+	case "flaky":
+		data, _ := ioutil.ReadFile(args[0])
+		runs := len(data) + 1
+		_ = ioutil.WriteFile(args[0], append(data, '.'), 0644)
+		if n, _ := strconv.Atoi(args[1]); runs < n {
+			fmt.Fprintf(os.Stderr, "run %d failed", runs)
+			os.Exit(3)
+		}
+		fmt.Print("run ", runs)
+	case "closeout":
+		n, _ := strconv.Atoi(args[0])
+		ms, _ := strconv.Atoi(args[1])
+		fmt.Print("out")
+		os.Stdout.Close()
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		fmt.Fprint(os.Stderr, strings.Repeat("e", n))
+	case "latewrite":
+		if args[0] == "child" {
+			ms, _ := strconv.Atoi(args[1])
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			fmt.Fprint(os.Stderr, args[2])
+			return
+		}
+		child := fakeExecCommand(context.Background(), "latewrite", append([]string{"child"}, args...)...)
+		child.Stderr = os.Stderr
+		_ = child.Start()
+	case "nul":
+		for _, arg := range args {
+			fmt.Print(arg, "\x00")
+		}
+	case "hang":
+		d := time.Minute
+		if len(args) > 0 {
+			ms, _ := strconv.Atoi(args[0])
+			d = time.Duration(ms) * time.Millisecond
+		}
+		time.Sleep(d)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown helper command %q", name)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func newFakeManager() *Manager {
+	pm := &Manager{Processes: make(map[int64]*Process)}
+	pm.SetCommandFactory(fakeExecCommand)
+	return pm
+}
+
+func TestManager_SetCommandFactory(t *testing.T) {
+	pm := newFakeManager()
+
+	stdout, _, err := pm.Exec("FakeEcho", "echo", "hello", "world")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", stdout)
+
+	_, stderr, err := pm.Exec("FakeFail", "fail", "3", "boom")
+	assert.Error(t, err)
+	assert.Equal(t, "boom", stderr)
+	if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+		assert.Equal(t, 3, exitErr.ExitCode())
+	}
+
+	_, _, err = pm.ExecTimeout(100*time.Millisecond, "FakeHang", "hang")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+
+	pm.SetCommandFactory(nil)
+	_, _, err = pm.Exec("RealGit", "git", "--version")
+	assert.NoError(t, err)
+}
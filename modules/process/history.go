@@ -0,0 +1,164 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "time"
+
+// DefaultHistorySize is the number of finished processes remembered by NewManager.
+const DefaultHistorySize = 100
+
+// HistoryEntry describes a finished process.
+type HistoryEntry struct {
+	PID         int64
+	Manager     string // name of the manager
+	InstanceID  string // instance ID of the manager
+	Description string
+	RequestID   string
+	Attempt     int      // see Process.Attempt
+	Argv        []string // see Process.Argv
+	Path        string   // see Result.ResolvedPath
+	Dir         string   // see Result.Dir
+	EnqueuedAt  time.Time
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	Error       string // empty if the process succeeded
+	Reason      string // why the process was killed, if it was with a reason, see KillWithReason
+	StdoutBytes int64  // total bytes written to stdout, including any dropped when over the output budget
+	StderrBytes int64
+}
+
+// QueueWait returns how long the process waited for a free slot before starting.
+func (e *HistoryEntry) QueueWait() time.Duration {
+	return e.StartedAt.Sub(e.EnqueuedAt)
+}
+
+// RunTime returns how long the process ran.
+func (e *HistoryEntry) RunTime() time.Duration {
+	return e.FinishedAt.Sub(e.StartedAt)
+}
+
+// history is a fixed-size ring of finished processes.
+type history struct {
+	entries      []HistoryEntry
+	keys         []string // normalized descriptions of the entries, with a per-description cap
+	start, count int
+	perKey       int
+}
+
+func newHistory(size, perKey int) history {
+	h := history{entries: make([]HistoryEntry, size), perKey: perKey}
+	if perKey > 0 {
+		h.keys = make([]string, size)
+	}
+	return h
+}
+
+// SetHistorySize sets how many finished processes are remembered and clears the history.
+// A size of 0 disables it.
+func (pm *Manager) SetHistorySize(size int) {
+	pm.mutex.Lock()
+	pm.history = newHistory(size, pm.history.perKey)
+	pm.mutex.Unlock()
+}
+
+// SetHistoryPerDescription keeps at most n entries per description in the history, the
+// oldest of them being evicted first, so that a chatty command can't push the rare
+// failures of other commands out of it. Descriptions are compared once numbers and
+// commit IDs are normalized away, and the total is still bounded by SetHistorySize.
+// It clears the history. 0 disables the cap, which is the default.
+func (pm *Manager) SetHistoryPerDescription(n int) {
+	pm.mutex.Lock()
+	pm.history = newHistory(len(pm.history.entries), n)
+	pm.mutex.Unlock()
+}
+
+// History returns the remembered finished processes, oldest first.
+func (pm *Manager) History() []HistoryEntry {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	h := &pm.history
+	entries := make([]HistoryEntry, h.count)
+	for i := range entries {
+		entries[i] = h.entries[h.index(i)]
+	}
+	return entries
+}
+
+// recordHistoryLocked must be called with the mutex held.
+func (pm *Manager) recordHistoryLocked(entry HistoryEntry) {
+	h := &pm.history
+	if len(h.entries) == 0 {
+		return
+	}
+	var key string
+	if h.perKey > 0 {
+		key = normalizeDescription(entry.Description)
+		h.evictOldest(key)
+	}
+	if h.count == len(h.entries) {
+		h.start = h.index(1)
+		h.count--
+	}
+	i := h.index(h.count)
+	h.entries[i] = entry
+	if h.keys != nil {
+		h.keys[i] = key
+	}
+	h.count++
+}
+
+// index returns the position in the ring of the i-th oldest entry.
+func (h *history) index(i int) int {
+	return (h.start + i) % len(h.entries)
+}
+
+// evictOldest removes the oldest entry with key if the history holds perKey of them.
+func (h *history) evictOldest(key string) {
+	oldest, n := -1, 0
+	for i := 0; i < h.count; i++ {
+		if h.keys[h.index(i)] == key {
+			if oldest < 0 {
+				oldest = i
+			}
+			n++
+		}
+	}
+	if n < h.perKey {
+		return
+	}
+	// The newer entries move back one place.
+	for i := oldest; i < h.count-1; i++ {
+		to, from := h.index(i), h.index(i+1)
+		h.entries[to], h.keys[to] = h.entries[from], h.keys[from]
+	}
+	last := h.index(h.count - 1)
+	h.entries[last], h.keys[last] = HistoryEntry{}, ""
+	h.count--
+}
+
+func (p *Process) historyEntry(pm *Manager) HistoryEntry {
+	stdoutBytes, stderrBytes := p.OutputBytes()
+	var path string
+	if p.Cmd != nil {
+		path = p.Cmd.Path
+	}
+	return HistoryEntry{
+		StdoutBytes: stdoutBytes,
+		StderrBytes: stderrBytes,
+		PID:         p.PID,
+		Manager:     pm.Name,
+		InstanceID:  p.InstanceID,
+		Description: p.CurrentDescription(),
+		RequestID:   p.RequestID,
+		Attempt:     p.Attempt,
+		Argv:        p.Argv,
+		Path:        path,
+		Dir:         p.dir,
+		EnqueuedAt:  p.EnqueuedAt,
+		StartedAt:   p.Start,
+		FinishedAt:  pm.timeNow(),
+		Reason:      p.KillReason(),
+	}
+}
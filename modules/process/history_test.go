@@ -0,0 +1,209 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_History(t *testing.T) {
+	pm := newFakeManager()
+	pm.Name = "web"
+	pm.SetHistorySize(3)
+
+	_, _, err := pm.Exec("first", "echo", "foo")
+	assert.NoError(t, err)
+	_, _, err = pm.Exec("second", "fail", "1", "boom")
+	assert.Error(t, err)
+	pm.Remove(pm.Add("third", exec.Command("third")))
+
+	entries := pm.History()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "first", entries[0].Description)
+		assert.Equal(t, "web", entries[0].Manager)
+		assert.Empty(t, entries[0].Error)
+		assert.False(t, entries[0].FinishedAt.Before(entries[0].StartedAt))
+
+		assert.Equal(t, "second", entries[1].Description)
+		assert.Equal(t, "exit status 1", entries[1].Error)
+
+		assert.Equal(t, "third", entries[2].Description)
+		assert.Equal(t, entries[2].EnqueuedAt, entries[2].StartedAt)
+	}
+
+	_, _, err = pm.Exec("fourth", "echo", "foo")
+	assert.NoError(t, err)
+	entries = pm.History()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "second", entries[0].Description)
+		assert.Equal(t, "fourth", entries[2].Description)
+	}
+
+	pm.SetHistorySize(0)
+	_, _, err = pm.Exec("fifth", "echo", "foo")
+	assert.NoError(t, err)
+	assert.Empty(t, pm.History())
+}
+
+func TestManager_SetMaxConcurrent(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+	pm.SetMaxConcurrent(1)
+
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, _, err := pm.Exec("Limited", "spew", "1", "200")
+			assert.NoError(t, err)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	entries := pm.History()
+	if assert.Len(t, entries, 3) {
+		for i, entry := range entries {
+			if i > 0 {
+				assert.False(t, entry.StartedAt.Before(entries[i-1].FinishedAt), "processes overlapped")
+			}
+			assert.True(t, entry.RunTime() > 0)
+		}
+		assert.True(t, entries[1].QueueWait() > 0, "second process should have waited for a slot")
+		assert.True(t, entries[2].QueueWait() > 0, "third process should have waited for a slot")
+	}
+}
+
+func TestManager_SetMaxConcurrentWait(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetMaxConcurrent(1)
+
+	h, err := pm.Start("Busy", "hang", nil)
+	assert.NoError(t, err)
+
+	// Waiting for a slot stops with the context of the command
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = pm.ExecWithOptions("Queued", "echo", nil, WithContext(ctx))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// and with the manager
+	done := make(chan error)
+	go func() {
+		_, _, err := pm.Exec("Queued", "echo")
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, pm.Close())
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrClosed, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued command was not released by Close")
+	}
+	_, _, err = h.Wait()
+	assert.Error(t, err)
+}
+
+func TestManager_HistoryOutputBytes(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	stdout, _, err := pm.Exec("Spew", "spew", "1234", "0")
+	assert.NoError(t, err)
+	_, stderr, err := pm.Exec("Fail", "fail", "1", "fatal: boom")
+	assert.Error(t, err)
+
+	entries := pm.History()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, int64(len(stdout)), entries[0].StdoutBytes)
+		assert.Equal(t, int64(0), entries[0].StderrBytes)
+		assert.Equal(t, int64(0), entries[1].StdoutBytes)
+		assert.Equal(t, int64(len(stderr)), entries[1].StderrBytes)
+	}
+}
+
+func TestManager_SetHistoryPerDescription(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+	pm.SetHistoryPerDescription(3)
+
+	pm.Remove(pm.Add("git gc", nil))
+	for i := 1; i <= 50; i++ {
+		pm.Remove(pm.Add(fmt.Sprintf("git fetch repo %d", i), nil))
+		if i == 20 {
+			pm.Remove(pm.Add("git fsck", nil))
+		}
+	}
+
+	var descs []string
+	for _, entry := range pm.History() {
+		descs = append(descs, entry.Description)
+	}
+	assert.Equal(t, []string{"git gc", "git fsck", "git fetch repo 48", "git fetch repo 49", "git fetch repo 50"}, descs,
+		"the other descriptions must survive the flood, oldest first")
+
+	// The total stays bounded by the size of the history
+	for i := 1; i <= 20; i++ {
+		pm.Remove(pm.Add(fmt.Sprintf("command %c", 'a'+i), nil))
+	}
+	assert.Len(t, pm.History(), 10)
+
+	pm.SetHistoryPerDescription(0)
+	for i := 1; i <= 20; i++ {
+		pm.Remove(pm.Add(fmt.Sprintf("git fetch repo %d", i), nil))
+	}
+	assert.Len(t, pm.History(), 10)
+	assert.Equal(t, "git fetch repo 11", pm.History()[0].Description)
+}
+
+func TestManager_KillWithReason(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	admin, err := pm.Start("Admin", "hang", nil)
+	assert.NoError(t, err)
+	timeout, err := pm.Start("Timeout", "hang", nil, WithTimeout(300*time.Millisecond))
+	assert.NoError(t, err)
+	shutdown, err := pm.Start("Shutdown", "hang", nil)
+	assert.NoError(t, err)
+	exited, err := pm.Start("Exited", "echo", nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, pm.KillWithReason(admin.PID(), "killed by admin john"))
+	_, _, err = admin.Wait()
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "killed by admin john", execErr.Reason)
+		assert.Contains(t, err.Error(), "killed: killed by admin john")
+	}
+	_, _, err = timeout.Wait()
+	assert.True(t, errors.As(err, &execErr))
+	assert.Equal(t, KillReasonTimeout, execErr.Reason)
+	_, _, err = exited.Wait()
+	assert.NoError(t, err)
+	assert.NoError(t, pm.Close())
+	_, _, err = shutdown.Wait()
+	assert.Error(t, err)
+
+	reasons := make(map[string]string)
+	for _, entry := range pm.History() {
+		reasons[entry.Description] = entry.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"Admin":    "killed by admin john",
+		"Timeout":  KillReasonTimeout,
+		"Shutdown": KillReasonShutdown,
+		"Exited":   "",
+	}, reasons)
+}
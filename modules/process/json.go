@@ -0,0 +1,23 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ExecJSON runs a command with the default timeout and decodes its stdout as JSON into out,
+// which must be a pointer as for json.Unmarshal.
+func (pm *Manager) ExecJSON(out interface{}, desc, cmdName string, args ...string) error {
+	stdout, stderr, err := pm.Exec(desc, cmdName, args...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(stdout), out); err != nil {
+		return fmt.Errorf("exec(%s): unable to decode JSON output: %v stderr: %s", desc, err, stderr)
+	}
+	return nil
+}
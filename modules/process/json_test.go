@@ -0,0 +1,33 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_ExecJSON(t *testing.T) {
+	pm := newFakeManager()
+
+	var out struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	assert.NoError(t, pm.ExecJSON(&out, "ValidJSON", "echo", `{"name": "gitea", "count": 3}`))
+	assert.Equal(t, "gitea", out.Name)
+	assert.Equal(t, 3, out.Count)
+
+	err := pm.ExecJSON(&out, "InvalidJSON", "echo", `{"name":`)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exec(InvalidJSON): unable to decode JSON output")
+	}
+
+	err = pm.ExecJSON(&out, "FailingJSON", "fail", "1", "fatal: not a git repository")
+	if assert.Error(t, err) {
+		assert.IsType(t, &ExecError{}, err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "os"
+
+// WithKey sets a key chosen by the caller, e.g. the repository and the operation, so that
+// the command can be killed or signalled with KillByKey or SignalByKey without knowing
+// its PID. Keys need not be unique: those functions act on all the processes sharing one.
+func WithKey(key string) RunOption {
+	return func(o *runOptions) {
+		o.key = key
+	}
+}
+
+// KillByKey kills and removes all the processes with the given key, returning the first
+// error encountered, or ErrNotFound if there is none. Like KillAll, it forgets the
+// processes whose command has not been started. The empty key, that of the processes
+// without key, matches none.
+func (pm *Manager) KillByKey(key string) error {
+	if key == "" {
+		return ErrNotFound
+	}
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	found := false
+	var firstErr error
+	for pid, proc := range pm.Processes {
+		if proc.Key != key {
+			continue
+		}
+		found = true
+		err := pm.kill(pid, "")
+		if err == ErrNotStarted {
+			pm.removeLocked(pid)
+		} else if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return firstErr
+}
+
+// SignalByKey sends sig to all the started processes with the given key, returning the
+// first error encountered, or ErrNotFound if there is none. Like with KillByKey, the
+// empty key matches none.
+func (pm *Manager) SignalByKey(key string, sig os.Signal) error {
+	if key == "" {
+		return ErrNotFound
+	}
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	found := false
+	var firstErr error
+	for pid, proc := range pm.Processes {
+		if proc.Key != key {
+			continue
+		}
+		found = true
+		_, p, err := pm.startedLocked(pid)
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil && err != ErrNotStarted && err.Error() != errProcessDone && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return firstErr
+}
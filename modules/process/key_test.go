@@ -0,0 +1,57 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_KillByKey(t *testing.T) {
+	pm := newFakeManager()
+
+	var handles []*Handle
+	for _, key := range []string{"user/repo:gc", "user/repo:gc", "user/other:gc"} {
+		h, err := pm.Start("Hang", "hang", nil, WithKey(key))
+		assert.NoError(t, err)
+		handles = append(handles, h)
+	}
+	assert.Equal(t, "user/repo:gc", pm.Snapshot().Processes[0].Key)
+
+	assert.NoError(t, pm.KillByKey("user/repo:gc"))
+	<-handles[0].Done()
+	<-handles[1].Done()
+	select {
+	case <-handles[2].Done():
+		t.Fatal("a process with another key was killed")
+	default:
+	}
+	assert.Equal(t, ErrNotFound, pm.KillByKey("user/repo:gc"))
+	assert.Equal(t, ErrNotFound, pm.SignalByKey("user/repo:gc", os.Kill))
+
+	assert.NoError(t, pm.SignalByKey("user/other:gc", os.Kill))
+	_, _, err := handles[2].Wait()
+	assert.Error(t, err)
+	assert.Equal(t, 0, pm.Count())
+}
+
+func TestManager_KillByKeyEmpty(t *testing.T) {
+	pm := newFakeManager()
+
+	pid := pm.Add("Added", nil)
+	h, err := pm.Start("Hang", "hang", nil)
+	assert.NoError(t, err)
+
+	// The processes without key are not matched by the empty key
+	assert.Equal(t, ErrNotFound, pm.KillByKey(""))
+	assert.Equal(t, ErrNotFound, pm.SignalByKey("", os.Kill))
+	assert.Equal(t, 2, pm.Count())
+
+	assert.NoError(t, pm.Kill(h.PID()))
+	_, _, _ = h.Wait()
+	pm.Remove(pid)
+}
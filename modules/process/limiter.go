@@ -0,0 +1,126 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"sync"
+)
+
+// limiter bounds the number of processes run concurrently by the manager, in total
+// and by category.
+type limiter struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	max     int
+	running int
+	closed  bool // wakes up the waiters for good, see Manager.Close
+
+	maxByCategory     map[Category]int
+	runningByCategory map[Category]int
+}
+
+// SetMaxConcurrent limits the number of commands the manager runs at the same time.
+// Further commands wait for a free slot before being started, until their context is
+// done or the manager is closed. 0 means unlimited.
+// It does not apply to processes added with Add or Register.
+func (pm *Manager) SetMaxConcurrent(n int) {
+	l := &pm.limiter
+	l.mutex.Lock()
+	l.max = n
+	l.mutex.Unlock()
+	l.broadcast()
+}
+
+// SetMaxConcurrentByCategory limits the number of commands of category c the manager runs
+// at the same time, on top of the limit of SetMaxConcurrent, e.g. so that mirror syncs
+// always leave slots to interactive git commands. 0 means no limit but the total one.
+func (pm *Manager) SetMaxConcurrentByCategory(c Category, n int) {
+	l := &pm.limiter
+	l.mutex.Lock()
+	if l.maxByCategory == nil {
+		l.maxByCategory = make(map[Category]int)
+	}
+	if n > 0 {
+		l.maxByCategory[c] = n
+	} else {
+		delete(l.maxByCategory, c)
+	}
+	l.mutex.Unlock()
+	l.broadcast()
+}
+
+// full must be called with the mutex held.
+func (l *limiter) full(c Category) bool {
+	if l.max > 0 && l.running >= l.max {
+		return true
+	}
+	max := l.maxByCategory[c]
+	return max > 0 && l.runningByCategory[c] >= max
+}
+
+// acquire waits for a free slot for a command of category c. It gives up with the error
+// of ctx once ctx is done, or with ErrClosed once the manager is closed.
+func (l *limiter) acquire(ctx context.Context, c Category) error {
+	l.mutex.Lock()
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mutex)
+	}
+	if l.full(c) && ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				l.broadcast()
+			case <-stop:
+			}
+		}()
+	}
+	for l.full(c) {
+		if l.closed {
+			l.mutex.Unlock()
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			l.mutex.Unlock()
+			return err
+		}
+		l.cond.Wait()
+	}
+	l.running++
+	if l.runningByCategory == nil {
+		l.runningByCategory = make(map[Category]int)
+	}
+	l.runningByCategory[c]++
+	l.mutex.Unlock()
+	return nil
+}
+
+func (l *limiter) release(c Category) {
+	l.mutex.Lock()
+	l.running--
+	if l.runningByCategory[c]--; l.runningByCategory[c] == 0 {
+		delete(l.runningByCategory, c)
+	}
+	l.mutex.Unlock()
+	l.broadcast()
+}
+
+// close makes the commands waiting for a slot fail with ErrClosed.
+func (l *limiter) close() {
+	l.mutex.Lock()
+	l.closed = true
+	l.mutex.Unlock()
+	l.broadcast()
+}
+
+func (l *limiter) broadcast() {
+	l.mutex.Lock()
+	if l.cond != nil {
+		l.cond.Broadcast()
+	}
+	l.mutex.Unlock()
+}
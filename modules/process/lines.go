@@ -0,0 +1,47 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "strings"
+
+// ExecLines runs a command with the default timeout and returns the lines of its stdout
+// with their surrounding spaces trimmed, leaving out the empty ones, e.g. for the output
+// of "git branch --format=%(refname)".
+func (pm *Manager) ExecLines(desc, cmdName string, args ...string) ([]string, error) {
+	stdout, _, err := pm.Exec(desc, cmdName, args...)
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(stdout), nil
+}
+
+// ExecNulSeparated runs a command with the default timeout and returns the fields of its
+// stdout separated by NUL characters, e.g. for the output of git commands run with -z.
+// The fields are left as they are, as they may be paths, but a trailing NUL doesn't add
+// an empty field.
+func (pm *Manager) ExecNulSeparated(desc, cmdName string, args ...string) ([]string, error) {
+	stdout, _, err := pm.Exec(desc, cmdName, args...)
+	if err != nil {
+		return nil, err
+	}
+	return splitNulSeparated(stdout), nil
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func splitNulSeparated(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\x00"), "\x00")
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitLines(t *testing.T) {
+	for s, lines := range map[string][]string{
+		"":                           nil,
+		"\n":                         nil,
+		"master":                     {"master"},
+		"master\n":                   {"master"},
+		"  master \n\n* develop\r\n": {"master", "* develop"},
+	} {
+		assert.Equal(t, lines, splitLines(s), "%q", s)
+	}
+}
+
+func TestSplitNulSeparated(t *testing.T) {
+	for s, fields := range map[string][]string{
+		"":                    nil,
+		"\x00":                {""},
+		"a.txt":               {"a.txt"},
+		"a.txt\x00":           {"a.txt"},
+		" a b.txt\x00\nc\x00": {" a b.txt", "\nc"},
+	} {
+		assert.Equal(t, fields, splitNulSeparated(s), "%q", s)
+	}
+}
+
+func TestManager_ExecLines(t *testing.T) {
+	pm := newFakeManager()
+
+	lines, err := pm.ExecLines("Lines", "lines", "3", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 1", "line 2", "line 3"}, lines, "the trailing newline adds no line")
+
+	lines, err = pm.ExecLines("Empty", "echo")
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+
+	fields, err := pm.ExecNulSeparated("Nul", "nul", "a.txt", "dir/b c.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "dir/b c.txt"}, fields, "the trailing NUL adds no field")
+
+	_, err = pm.ExecNulSeparated("Fail", "fail", "1", "boom")
+	assert.Error(t, err)
+}
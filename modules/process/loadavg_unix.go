@@ -0,0 +1,34 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// readLoadAverage returns the 1-minute load average from /proc/loadavg, which only
+// exists on Linux: elsewhere it fails, and LoadAverageAdmission admits everything.
+// It is a variable to be stubbed in tests.
+var readLoadAverage = func() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	return parseLoadAverage(string(data))
+}
+
+// parseLoadAverage parses the content of /proc/loadavg, e.g. "0.52 0.58 0.59 1/467 12345".
+func parseLoadAverage(data string) (float64, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid load average: %q", data)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,54 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAverageAdmission(t *testing.T) {
+	defer func(read func() (float64, error)) {
+		readLoadAverage = read
+	}(readLoadAverage)
+	var load float64
+	var loadErr error
+	readLoadAverage = func() (float64, error) {
+		return load, loadErr
+	}
+
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+	pm.SetAdmissionFunc(LoadAverageAdmission(4))
+
+	load = 8.5
+	_, _, err := pm.Exec("Overloaded", "echo", "hello")
+	assert.Equal(t, ErrSystemOverloaded, err)
+	assert.Empty(t, pm.History(), "the command should not have been forked")
+
+	load = 1.5
+	stdout, _, err := pm.Exec("Idle", "echo", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", stdout)
+
+	loadErr = errors.New("no /proc")
+	load = 8.5
+	assert.NoError(t, LoadAverageAdmission(4)(), "commands are admitted without a load average")
+}
+
+func TestParseLoadAverage(t *testing.T) {
+	load, err := parseLoadAverage("0.52 0.58 0.59 1/467 12345\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.52, load)
+
+	_, err = parseLoadAverage("")
+	assert.Error(t, err)
+	_, err = parseLoadAverage("high 0.58 0.59 1/467 12345")
+	assert.Error(t, err)
+}
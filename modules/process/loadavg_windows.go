@@ -0,0 +1,13 @@
+// +build windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+// readLoadAverage fails as there is no load average on Windows, so LoadAverageAdmission
+// admits everything.
+var readLoadAverage = func() (float64, error) {
+	return 0, ErrUnsupported
+}
@@ -6,14 +6,21 @@
 package process
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"code.gitea.io/gitea/modules/log"
 )
 
 // TODO: This packages still uses a singleton for the Manager.
@@ -23,56 +30,477 @@ import (
 var (
 	// ErrExecTimeout represent a timeout error
 	ErrExecTimeout = errors.New("Process execution timeout")
-	manager        *Manager
+	// ErrStartupTimeout is the timeout error of a command killed as it produced no output within
+	// its startup timeout, see Timeouts. The ExecError then matches ErrExecTimeout too
+	ErrStartupTimeout = errors.New("Process startup timeout")
+	// ErrNoStdin is returned by CloseStdin when the process has no stdin pipe owned by the manager
+	ErrNoStdin = errors.New("Process has no manager-owned stdin")
+	// ErrUnexpectedStderr is the cause of the ExecError returned when a command run with
+	// WithFailOnStderr exits successfully but wrote to its stderr, available in the ExecError
+	ErrUnexpectedStderr = errors.New("Process wrote to stderr")
+	// ErrNoDeadline is returned by ExtendDeadline when the process has no deadline owned by the manager
+	ErrNoDeadline = errors.New("Process has no manager-owned deadline")
+	// ErrDeadlineLimit is returned by ExtendDeadline when the deadline can't be extended as far as requested
+	ErrDeadlineLimit = errors.New("Process deadline extension limit reached")
+	// ErrUnsupported is returned by operations that are not available on the current platform
+	ErrUnsupported = errors.New("Operation not supported on this platform")
+	// ErrNotFound is returned when a PID is not tracked by the manager
+	ErrNotFound = errors.New("Process not found")
+	// ErrNotStarted is returned when signalling a tracked process whose command has not been started
+	ErrNotStarted = errors.New("Process has not been started")
+	// ErrClosed is returned when executing a command with a closed manager
+	ErrClosed = errors.New("Process manager is closed")
+	// ErrDraining is returned when executing a command while the manager is drained
+	ErrDraining = errors.New("Process manager is draining")
+	// ErrNoWorkerPool is returned by Submit when the manager has no worker pool
+	ErrNoWorkerPool = errors.New("Process manager has no worker pool")
+	// ErrQueueFull is returned by Submit when the queue of the worker pool is full
+	ErrQueueFull = errors.New("Process queue is full")
+	// ErrKillTimeout is returned by Terminate when the process is still alive once killed
+	ErrKillTimeout = errors.New("Process did not die after being killed")
+	// ErrSpawnRateExceeded is returned when a command is spawned more often than allowed by SetSpawnRateLimit
+	ErrSpawnRateExceeded = errors.New("Process spawn rate exceeded")
+	// ErrCircuitOpen is returned when a command keeps failing and its circuit breaker is open, see SetCircuitBreaker
+	ErrCircuitOpen = errors.New("Process circuit breaker is open")
+	// ErrSystemOverloaded is returned when the load average of the host is too high, see LoadAverageAdmission
+	ErrSystemOverloaded = errors.New("Process not started as the system is overloaded")
+	// ErrStdinLimitExceeded is the cause of the ExecError returned when a command is killed
+	// as its stdin exceeded the limit set with WithStdinLimit
+	ErrStdinLimitExceeded = errors.New("Process stdin limit exceeded")
+	manager               *Manager
 )
 
+// errProcessDone is the message of the error returned by os.Process methods once the process has been waited for.
+const errProcessDone = "os: process already finished"
+
 // Process represents a working process inherit from Gogs.
 type Process struct {
-	PID         int64 // Process ID, not system one.
+	// 64-bit fields accessed atomically come first to keep them aligned on 32-bit platforms.
+	stdoutBytes, stderrBytes int64
+	stdinBytes               int64
+
+	PID int64 // Process ID, not system one.
+	// Description is the description the process was added with, kept up to date by
+	// Manager.SetDescription. Copies returned by the manager hold the latest one, also
+	// set with Process.SetDescription, which CurrentDescription returns.
 	Description string
-	Start       time.Time
-	Cmd         *exec.Cmd
+	// Start is set once when the process is added, before it is visible in Processes,
+	// and never changed afterwards: it is safe to read concurrently, see StartedAt.
+	Start time.Time
+	Cmd   *exec.Cmd
+	// EnqueuedAt is when the process was requested, before waiting for a free slot if
+	// concurrency is limited. It equals Start for processes added directly.
+	EnqueuedAt time.Time
+	// RequestID ties the process to the request that spawned it, e.g. for log correlation.
+	// It is set by WithRequestID for commands run by the manager.
+	RequestID string
+	// Category is set by WithCategory for commands run by the manager.
+	Category Category
+	// Key is set by WithKey for commands run by the manager.
+	Key string
+	// Attempt is the attempt of ExecRetry the process is, 0 if it was not run by ExecRetry.
+	Attempt int
+	// InstanceID is the InstanceID of the manager when the process was added.
+	InstanceID string
+	// Argv is the command line of the process, as redacted by SetArgRedactor. The command
+	// run by the manager is not, only this copy. It is set from Cmd.Args for processes added
+	// with Add, and must not be modified.
+	Argv []string
+	// Env is the environment the command was started with, as redacted by SetEnvRedactor,
+	// if it was run by the manager with WithRecordEnv. It must not be modified.
+	Env []string
+
+	description atomic.Value // string, the latest description, see SetDescription
+	killReason  atomic.Value // string, see KillWithReason
+
+	stdin    io.WriteCloser // write side of the stdin pipe, if the manager wired one
+	deadline *deadline      // timeout of the command, if the manager started it
+	paused   int32          // accessed atomically
+	killed   int32          // accessed atomically, set once the manager has killed the process
+	managed  bool           // started by the manager, which records its history itself
+
+	reaperExempt, watchdogExempt bool // see WithExemptFromReaper and WithExemptFromWatchdog
+	privileges                   Privileges
+
+	stack string // of the goroutine adding or waiting for the process, see SetDebugStacks
+	dir   string // working directory of Cmd when the process was added, see commandDir
+}
+
+// StartedAt returns when the process was added to the manager. Unlike the
+// description, it never changes, so it is safe to read from any goroutine.
+func (p *Process) StartedAt() time.Time {
+	return p.Start
+}
+
+// CurrentDescription returns the latest description of the process, see SetDescription.
+func (p *Process) CurrentDescription() string {
+	if desc, ok := p.description.Load().(string); ok {
+		return desc
+	}
+	return p.Description
+}
+
+// SetDescription updates the description of the process, e.g. with the current phase
+// of a long import. It doesn't lock anything, so it can be called many times per second,
+// and hence leaves the Description field of the tracked process as is: CurrentDescription
+// and the copies returned by the manager give the latest description.
+func (p *Process) SetDescription(desc string) {
+	p.description.Store(desc)
+}
+
+// OutputBytes returns the number of bytes the process has written to its stdout and stderr
+// so far. They are only counted for commands run by the manager. The counts are updated
+// atomically, so it is safe to call while the process is writing, e.g. from a dashboard,
+// unlike reading its output buffers, which are only exposed once it has exited.
+func (p *Process) OutputBytes() (stdout, stderr int64) {
+	return atomic.LoadInt64(&p.stdoutBytes), atomic.LoadInt64(&p.stderrBytes)
+}
+
+// StdinBytes returns the number of bytes the manager has written to the stdin of the
+// process so far, with WithStdin. Like OutputBytes, it is safe to call while the process
+// is running.
+func (p *Process) StdinBytes() int64 {
+	return atomic.LoadInt64(&p.stdinBytes)
+}
+
+// Paused reports whether the process has been paused with Manager.Pause.
+func (p *Process) Paused() bool {
+	return atomic.LoadInt32(&p.paused) == 1
 }
 
-// Manager knows about all processes and counts PIDs.
+// snapshot returns a copy of the process that doesn't give access to its stdin.
+func (p *Process) snapshot() *Process {
+	snap := &Process{
+		stdoutBytes: atomic.LoadInt64(&p.stdoutBytes),
+		stderrBytes: atomic.LoadInt64(&p.stderrBytes),
+		stdinBytes:  atomic.LoadInt64(&p.stdinBytes),
+		PID:         p.PID,
+		Description: p.CurrentDescription(),
+		Start:       p.Start,
+		Cmd:         p.Cmd,
+		EnqueuedAt:  p.EnqueuedAt,
+		RequestID:   p.RequestID,
+		Category:    p.Category,
+		Key:         p.Key,
+		Attempt:     p.Attempt,
+		InstanceID:  p.InstanceID,
+		Argv:        p.Argv,
+		Env:         p.Env,
+		dir:         p.dir,
+		deadline:    p.deadline,
+		paused:      atomic.LoadInt32(&p.paused),
+
+		reaperExempt:   p.reaperExempt,
+		watchdogExempt: p.watchdogExempt,
+		privileges:     p.privileges,
+	}
+	snap.setKillReason(p.KillReason())
+	return snap
+}
+
+// CommandFactory creates the *exec.Cmd for a command, like exec.CommandContext does.
+type CommandFactory func(ctx context.Context, name string, args ...string) *exec.Cmd
+
+// noCopy makes go vet report the structs embedding it which are copied, as its
+// copylocks check does for sync.Mutex.
+type noCopy struct{}
+
+// Lock is a no-op used by go vet.
+func (*noCopy) Lock() {}
+
+// Unlock is a no-op used by go vet.
+func (*noCopy) Unlock() {}
+
+// Manager knows about all processes and counts PIDs. It must not be copied once
+// used, as the copy would allocate the PIDs of the original again: pass it by pointer,
+// as returned by NewManager and GetManager.
 type Manager struct {
-	mutex sync.Mutex
+	_ noCopy
+
+	// 64-bit fields accessed atomically come first to keep them aligned on 32-bit platforms.
+	outputBudget int64 // maximum buffered output bytes across all processes, 0 for unlimited
+	outputUsed   int64
+	stats        counters
+
+	mutex       sync.Mutex
+	debugStacks int32 // accessed atomically, see SetDebugStacks
+
+	// Name identifies the manager in error messages, e.g. "exec(web#42:...)".
+	// It is empty by default.
+	Name string
+	// InstanceID identifies the Gitea instance running the manager, e.g. the node of a
+	// cluster sharing its logs, in error messages, history entries and snapshots.
+	// It is empty by default. It must be set before the manager is used.
+	InstanceID string
 
 	counter   int64
 	Processes map[int64]*Process
+	idle      chan struct{} // closed once Processes is empty, created by WaitIdle
+	done      chan struct{} // closed by Close, created by NewManagerContext
+
+	tracer      Tracer
+	execCommand CommandFactory
+	now         func() time.Time // the clock, replaceable in tests
+	closed      bool
+	draining    bool
+	admission   AdmissionFunc
+	redactor    ArgRedactor
+	envRedactor EnvRedactor
+	audit       AuditFunc
+	spawnRate   spawnRate
+	breaker     breaker
+	flights     flights
+
+	limiter  limiter
+	history  history
+	watchdog *watchdog
+	reaper   *reaper
+	workers  *workerPool
+
+	defaultTimeouts map[Category]time.Duration // see SetDefaultTimeout
+	gitInfos        map[string]GitInfo         // by path, see ProbeGit
+	killOrder       []Category                 // see SetKillOrder
 }
 
-// GetManager returns a Manager and initializes one as singleton if there's none yet
+// GetManager returns a Manager and initializes one as singleton if there's none yet,
+// bound to the context given to SetShutdownContext if any.
 func GetManager() *Manager {
+	managerMutex.Lock()
+	defer managerMutex.Unlock()
 	if manager == nil {
-		manager = &Manager{
-			Processes: make(map[int64]*Process),
+		manager = NewManager()
+		if shutdownCtx != nil {
+			watchShutdownLocked()
 		}
 	}
 	return manager
 }
 
+// NewManager creates a new Manager. It should be released with Close once it is
+// not needed anymore. As a safety net, a warning is logged if it is garbage
+// collected while it still tracks processes.
+func NewManager() *Manager {
+	pm := &Manager{
+		Processes: make(map[int64]*Process),
+	}
+	pm.SetHistorySize(DefaultHistorySize)
+	runtime.SetFinalizer(pm, func(pm *Manager) {
+		if n := len(pm.Processes); n > 0 && !pm.closed {
+			log.Warn("Process manager %q garbage collected with %d live processes without being closed", pm.Name, n)
+		}
+	})
+	return pm
+}
+
+// NewManagerContext creates a new Manager closed once ctx is done: its processes are
+// killed and further executions fail with ErrClosed. This ties the manager to the
+// root context of a server, sparing a Close call from its shutdown path. Closing the
+// manager before ctx is done stops watching ctx.
+func NewManagerContext(ctx context.Context) *Manager {
+	pm := NewManager()
+	pm.done = make(chan struct{})
+	go func(done <-chan struct{}) {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		if err := pm.Close(); err != nil {
+			log.Warn("Process manager %q: %v", pm.Name, err)
+		}
+	}(pm.done)
+	return pm
+}
+
+// FormatPID returns the PID as it appears in logs and errors, prefixed with the
+// manager name if one is set.
+func (pm *Manager) FormatPID(pid int64) string {
+	if pm.Name == "" {
+		return strconv.FormatInt(pid, 10)
+	}
+	return pm.Name + "#" + strconv.FormatInt(pid, 10)
+}
+
+// SetCommandFactory replaces the function used to create commands, which defaults to
+// exec.CommandContext. It allows tests to run fakes instead of real binaries.
+// A nil factory restores the default.
+func (pm *Manager) SetCommandFactory(factory CommandFactory) {
+	pm.mutex.Lock()
+	pm.execCommand = factory
+	pm.mutex.Unlock()
+}
+
+func (pm *Manager) timeNow() time.Time {
+	if pm.now != nil {
+		return pm.now()
+	}
+	return time.Now()
+}
+
+func (pm *Manager) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	pm.mutex.Lock()
+	factory := pm.execCommand
+	pm.mutex.Unlock()
+	if factory == nil {
+		factory = exec.CommandContext
+	}
+	return factory(ctx, name, args...)
+}
+
 // Add a process to the ProcessManager and returns its PID.
 func (pm *Manager) Add(description string, cmd *exec.Cmd) int64 {
-	pm.mutex.Lock()
-	pid := pm.counter + 1
-	pm.Processes[pid] = &Process{
-		PID:         pid,
+	return pm.add(&Process{
 		Description: description,
-		Start:       time.Now(),
 		Cmd:         cmd,
+	})
+}
+
+// add assigns a PID to proc, sets its start time and tracks it.
+func (pm *Manager) add(proc *Process) int64 {
+	argv := proc.Argv
+	if argv == nil && proc.Cmd != nil {
+		argv = proc.Cmd.Args
+	}
+	proc.Argv = pm.redactArgv(argv)
+	// Once tracked, the Description field is only written under the mutex: unlocked
+	// readers go through CurrentDescription.
+	if proc.description.Load() == nil {
+		proc.description.Store(proc.Description)
+	}
+	proc.stack = pm.callerStack()
+	if proc.Cmd != nil {
+		proc.dir = commandDir(proc.Cmd)
 	}
+
+	pm.mutex.Lock()
+	pid := pm.counter + 1
+	proc.PID = pid
+	proc.Start = pm.timeNow()
+	proc.InstanceID = pm.InstanceID
+	if proc.EnqueuedAt.IsZero() {
+		proc.EnqueuedAt = proc.Start
+	}
+	pm.Processes[pid] = proc
 	pm.counter = pid
+	audit := pm.audit
+	info := proc.info(proc.Start)
 	pm.mutex.Unlock()
 
+	if audit != nil {
+		audit(info)
+	}
 	return pid
 }
 
+// Register tracks a command that was created and started by the caller, so that it
+// shows up in the process list and can be killed through the manager.
+// The caller must call Remove with the returned PID once cmd.Wait has returned.
+func (pm *Manager) Register(desc string, cmd *exec.Cmd) int64 {
+	return pm.Add(desc, cmd)
+}
+
+// Track registers cmd for the duration of run and removes it again once run returns,
+// even if it panics. run is expected to start and wait for cmd itself.
+func (pm *Manager) Track(desc string, cmd *exec.Cmd, run func() error) error {
+	pid := pm.Register(desc, cmd)
+	defer pm.Remove(pid)
+	return run()
+}
+
+// OlderThan returns copies of the processes that have been running for longer than age,
+// oldest first.
+func (pm *Manager) OlderThan(age time.Duration) []*Process {
+	pm.mutex.Lock()
+	now := pm.timeNow()
+	procs := make([]*Process, 0, len(pm.Processes))
+	for _, proc := range pm.Processes {
+		if now.Sub(proc.Start) > age {
+			procs = append(procs, proc.snapshot())
+		}
+	}
+	pm.mutex.Unlock()
+
+	sort.Slice(procs, func(i, j int) bool {
+		if procs[i].Start.Equal(procs[j].Start) {
+			return procs[i].PID < procs[j].PID
+		}
+		return procs[i].Start.Before(procs[j].Start)
+	})
+	return procs
+}
+
+// Count returns the number of processes currently tracked.
+func (pm *Manager) Count() int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return len(pm.Processes)
+}
+
+// WaitIdle waits until the manager tracks no process, e.g. to let the running
+// commands finish on shutdown without killing them, or until ctx is done.
+func (pm *Manager) WaitIdle(ctx context.Context) error {
+	pm.mutex.Lock()
+	if len(pm.Processes) == 0 {
+		pm.mutex.Unlock()
+		return nil
+	}
+	if pm.idle == nil {
+		pm.idle = make(chan struct{})
+	}
+	idle := pm.idle
+	pm.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Remove a process from the ProcessManager.
-func (pm *Manager) Remove(pid int64) {
+// It is the counterpart of Add and Register. It returns whether the process was
+// still tracked: removing it again, or after it was killed, is a no-op returning false.
+// PIDs are never reused, so a late Remove can't remove another process.
+func (pm *Manager) Remove(pid int64) bool {
 	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return pm.removeLocked(pid)
+}
+
+// Prune removes the processes that have been waited for but never removed, e.g. because
+// their owner panicked before calling Remove, and returns how many it removed. It is
+// meant to recover a stuck process table: unlike Kill, it never touches live processes.
+// Processes run by the manager are skipped as the manager removes them itself.
+func (pm *Manager) Prune() int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pruned := 0
+	for pid, proc := range pm.Processes {
+		if proc.managed || proc.Cmd == nil || proc.Cmd.ProcessState == nil {
+			continue
+		}
+		pm.removeLocked(pid)
+		pruned++
+	}
+	return pruned
+}
+
+// removeLocked must be called with the mutex held.
+func (pm *Manager) removeLocked(pid int64) bool {
+	proc, exists := pm.Processes[pid]
+	if !exists {
+		return false
+	}
 	delete(pm.Processes, pid)
-	pm.mutex.Unlock()
+	if !proc.managed {
+		pm.recordHistoryLocked(proc.historyEntry(pm))
+	}
+	if len(pm.Processes) == 0 && pm.idle != nil {
+		close(pm.idle)
+		pm.idle = nil
+	}
+	return true
 }
 
 // Exec a command and use the default timeout.
@@ -80,6 +508,13 @@ func (pm *Manager) Exec(desc, cmdName string, args ...string) (string, string, e
 	return pm.ExecDir(-1, "", desc, cmdName, args...)
 }
 
+// ExecOK runs a command with the default timeout and reports whether it exited with
+// code 0, e.g. for existence checks like "git rev-parse --verify". Its output is discarded.
+func (pm *Manager) ExecOK(desc, cmdName string, args ...string) bool {
+	_, _, err := pm.ExecWithOptions(desc, cmdName, args, WithStdoutWriter(ioutil.Discard))
+	return err == nil
+}
+
 // ExecTimeout a command and use a specific timeout duration.
 func (pm *Manager) ExecTimeout(timeout time.Duration, desc, cmdName string, args ...string) (string, string, error) {
 	return pm.ExecDir(timeout, "", desc, cmdName, args...)
@@ -103,55 +538,209 @@ func (pm *Manager) ExecDirEnv(timeout time.Duration, dir, desc string, env []str
 // Returns its complete stdout and stderr
 // outputs and an error, if any (including timeout)
 func (pm *Manager) ExecDirEnvStdIn(timeout time.Duration, dir, desc string, env []string, stdIn io.Reader, cmdName string, args ...string) (string, string, error) {
-	if timeout == -1 {
-		timeout = 60 * time.Second
+	o := defaultRunOptions()
+	o.timeout, o.dir, o.env, o.stdin = timeout, dir, env, stdIn
+	r, err := pm.execResult(desc, cmdName, args, o)
+	return r.Stdout, r.Stderr, err
+}
+
+// CloseStdin closes the manager-owned stdin pipe of a process, so that a command
+// reading its input until EOF (e.g. git receive-pack) can finish cleanly.
+// Unlike Kill, no signal is sent: the command decides itself how to exit,
+// which is the preferred way to cancel commands that consume stdin.
+// It returns ErrNoStdin if the process was not started with a stdin reader by the manager.
+func (pm *Manager) CloseStdin(pid int64) error {
+	pm.mutex.Lock()
+	proc, exists := pm.Processes[pid]
+	pm.mutex.Unlock()
+	if !exists || proc.stdin == nil {
+		return ErrNoStdin
 	}
+	return proc.stdin.Close()
+}
 
-	stdOut := new(bytes.Buffer)
-	stdErr := new(bytes.Buffer)
+// SetDescription updates the description of a tracked process, including its Description
+// field. Frequent updaters should rather keep the *Process, e.g. from WithAfterStart, and
+// call its SetDescription, which doesn't take the manager mutex.
+func (pm *Manager) SetDescription(pid int64, desc string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	proc, exists := pm.Processes[pid]
+	if !exists {
+		return ErrNotFound
+	}
+	proc.SetDescription(desc)
+	proc.Description = desc
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+// Pause stops a process with SIGSTOP until it is resumed, e.g. to throttle a runaway
+// background git gc without killing it. Only the process itself is signalled, not
+// its children. It returns ErrUnsupported on Windows.
+func (pm *Manager) Pause(pid int64) error {
+	return pm.setPaused(pid, true)
+}
 
-	cmd := exec.CommandContext(ctx, cmdName, args...)
-	cmd.Dir = dir
-	cmd.Env = env
-	cmd.Stdout = stdOut
-	cmd.Stderr = stdErr
-	if stdIn != nil {
-		cmd.Stdin = stdIn
+// Resume continues a process paused with Pause using SIGCONT.
+// It returns ErrUnsupported on Windows.
+func (pm *Manager) Resume(pid int64) error {
+	return pm.setPaused(pid, false)
+}
+
+func (pm *Manager) setPaused(pid int64, paused bool) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	proc, p, err := pm.startedLocked(pid)
+	if err != nil {
+		return err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return "", "", err
+	if paused {
+		err = pauseProcess(p)
+	} else {
+		err = resumeProcess(p)
+	}
+	if err != nil {
+		return err
 	}
+	if paused {
+		atomic.StoreInt32(&proc.paused, 1)
+	} else {
+		atomic.StoreInt32(&proc.paused, 0)
+	}
+	return nil
+}
 
-	pid := pm.Add(desc, cmd)
-	err := cmd.Wait()
-	pm.Remove(pid)
+// startedLocked returns the tracked process of pid and its OS process, ErrNotFound if
+// it is not tracked and ErrNotStarted if its command has not been started, as can be
+// the case of processes added with Add. It must be called with the mutex held.
+func (pm *Manager) startedLocked(pid int64) (*Process, *os.Process, error) {
+	proc, exists := pm.Processes[pid]
+	if !exists {
+		return nil, nil, ErrNotFound
+	}
+	if proc.Cmd == nil || proc.Cmd.Process == nil {
+		return proc, nil, ErrNotStarted
+	}
+	return proc, proc.Cmd.Process, nil
+}
 
+// Signal sends sig to a tracked process, e.g. SIGHUP to make a daemon reload.
+// Only the process itself is signalled, not its children.
+func (pm *Manager) Signal(pid int64, sig os.Signal) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	_, p, err := pm.startedLocked(pid)
 	if err != nil {
-		err = fmt.Errorf("exec(%d:%s) failed: %v(%v) stdout: %v stderr: %v", pid, desc, err, ctx.Err(), stdOut, stdErr)
+		return err
 	}
-
-	return stdOut.String(), stdErr.String(), err
+	return p.Signal(sig)
 }
 
-// Kill and remove a process from list.
+// Kill and remove a process from list. It returns ErrNotStarted, leaving the process
+// tracked, if its command has not been started.
 func (pm *Manager) Kill(pid int64) error {
-	if proc, exists := pm.Processes[pid]; exists {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return pm.kill(pid, "")
+}
+
+// KillOSPID kills and removes the tracked process with the given OS PID, as shown by ps,
+// as a last resort when its manager PID is unknown. It returns ErrNotFound rather
+// than killing a process the manager doesn't track, which may be unrelated to Gitea.
+// Like Kill, it only kills the process itself, not its children.
+func (pm *Manager) KillOSPID(ospid int) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	for pid, proc := range pm.Processes {
+		if proc.Cmd != nil && proc.Cmd.Process != nil && proc.Cmd.Process.Pid == ospid {
+			return pm.kill(pid, "")
+		}
+	}
+	return ErrNotFound
+}
+
+// KillAll kills and removes all processes, returning the first error encountered.
+// They are killed by category in the order set with SetKillOrder, background work
+// first by default, and then by PID. The processes of a category are waited for, up
+// to DefaultKillTimeout, before the next category is killed, so that the processes
+// killed later can still complete the work of those killed earlier.
+func (pm *Manager) KillAll() error {
+	return pm.killAll("")
+}
+
+func (pm *Manager) killAll(reason string) error {
+	pm.mutex.Lock()
+	tiers := pm.killTiersLocked()
+	pm.mutex.Unlock()
+
+	var firstErr error
+	for i, tier := range tiers {
+		var killed []*os.Process
 		pm.mutex.Lock()
-		if proc.Cmd != nil &&
-			proc.Cmd.Process != nil &&
-			proc.Cmd.ProcessState != nil &&
-			!proc.Cmd.ProcessState.Exited() {
-			if err := proc.Cmd.Process.Kill(); err != nil {
-				return fmt.Errorf("failed to kill process(%d/%s): %v", pid, proc.Description, err)
+		for _, pid := range tier {
+			_, p, _ := pm.startedLocked(pid)
+			err := pm.kill(pid, reason)
+			if err == ErrNotStarted {
+				// There is nothing to kill: it is only forgotten.
+				pm.removeLocked(pid)
+			} else if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else if p != nil {
+				killed = append(killed, p)
 			}
 		}
-		delete(pm.Processes, pid)
 		pm.mutex.Unlock()
+
+		if i == len(tiers)-1 {
+			break
+		}
+		deadline := time.Now().Add(DefaultKillTimeout)
+		for _, p := range killed {
+			if !waitForExit(p, time.Until(deadline)) {
+				log.Warn("Process with OS PID %d is still alive %v after being killed, killing the next processes anyway", p.Pid, DefaultKillTimeout)
+				break
+			}
+		}
 	}
+	return firstErr
+}
 
+// Close kills all processes and marks the manager as closed: further executions fail
+// with ErrClosed, as do those waiting for a free slot, see SetMaxConcurrent. Close is the
+// right way to release a manager that is no longer used.
+func (pm *Manager) Close() error {
+	pm.StopWatchdog()
+	pm.StopReaper()
+	pm.mutex.Lock()
+	if !pm.closed && pm.done != nil {
+		close(pm.done)
+	}
+	pm.closed = true
+	pm.mutex.Unlock()
+	pm.limiter.close()
+	pm.stopWorkerPool()
+	return pm.killAll(KillReasonShutdown)
+}
+
+// kill must be called with the mutex held.
+func (pm *Manager) kill(pid int64, reason string) error {
+	proc, p, err := pm.startedLocked(pid)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	proc.setKillReason(reason)
+	atomic.StoreInt32(&proc.killed, 1)
+	// The process may have exited and been waited for without being removed yet.
+	if err := p.Kill(); err == nil {
+		atomic.AddInt64(&pm.stats.killed, 1)
+	} else if err.Error() != errProcessDone {
+		return fmt.Errorf("failed to kill process(%s/%s): %v", pm.FormatPID(pid), proc.CurrentDescription(), err)
+	}
+	pm.removeLocked(pid)
 	return nil
 }
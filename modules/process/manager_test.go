@@ -1,13 +1,33 @@
 package process
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"runtime"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// eventually is assert.Eventually checking the condition in the calling goroutine:
+// testify 1.4 runs it in a goroutine per tick, which panics with a send on a closed
+// channel when a slow check is still running after another one succeeded.
+func eventually(t *testing.T, condition func() bool, waitFor, tick time.Duration, msgAndArgs ...interface{}) bool {
+	deadline := time.Now().Add(waitFor)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return assert.Fail(t, "Condition never satisfied", msgAndArgs...)
+		}
+		time.Sleep(tick)
+	}
+	return true
+}
+
 func TestManager_Add(t *testing.T) {
 	pm := Manager{Processes: make(map[int64]*Process)}
 
@@ -27,10 +47,82 @@ func TestManager_Remove(t *testing.T) {
 	pid2 := pm.Add("bar", exec.Command("bar"))
 	assert.Equal(t, int64(2), pid2, "expected to get pid 2 got %d", pid2)
 
-	pm.Remove(pid2)
+	assert.True(t, pm.Remove(pid2))
 
 	_, exists := pm.Processes[pid2]
 	assert.False(t, exists, "PID %d is in the list but shouldn't", pid2)
+
+	assert.False(t, pm.Remove(pid2), "PID %d was removed twice", pid2)
+}
+
+func TestManager_RemoveKillRace(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	pm.SetHistorySize(1000)
+
+	for i := 0; i < 100; i++ {
+		pid := pm.Add("foo", exec.Command("foo"))
+		removed := make(chan bool)
+		go func() {
+			removed <- pm.Remove(pid)
+		}()
+		// Kill doesn't remove the process as it was not started, unless Remove won the race
+		if err := pm.Kill(pid); err != ErrNotStarted {
+			assert.NoError(t, err)
+		}
+		<-removed
+		assert.False(t, pm.Remove(pid))
+	}
+	assert.Equal(t, 0, pm.Count())
+	assert.Len(t, pm.History(), 100, "a process was recorded twice")
+}
+
+func TestManager_Prune(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	// Its owner ran the command but never removed it
+	finished := exec.Command("git", "--version")
+	assert.NoError(t, finished.Run())
+	stuck := pm.Add("stuck", finished)
+	notStarted := pm.Add("not started", exec.Command("foo"))
+	h, err := pm.Start("Running", "hang", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, pm.Prune())
+	assert.Equal(t, 2, pm.Count())
+	_, exists := pm.Processes[stuck]
+	assert.False(t, exists)
+	_, exists = pm.Processes[notStarted]
+	assert.True(t, exists)
+	assert.Equal(t, 0, pm.Prune())
+
+	history := pm.History()
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "stuck", history[0].Description)
+	}
+
+	assert.NoError(t, pm.Kill(h.PID()))
+	<-h.Done()
+}
+
+func TestManager_Track(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	cmd := exec.Command("git", "--version")
+	err := pm.Track("foo", cmd, func() error {
+		assert.Len(t, pm.Processes, 1)
+		return cmd.Run()
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, pm.Processes)
+
+	assert.Panics(t, func() {
+		_ = pm.Track("bar", exec.Command("bar"), func() error {
+			assert.Len(t, pm.Processes, 1)
+			panic("bar")
+		})
+	})
+	assert.Empty(t, pm.Processes, "process should be removed after a panic")
 }
 
 func TestExecTimeoutNever(t *testing.T) {
@@ -56,3 +148,310 @@ func TestExecTimeoutAlways(t *testing.T) {
 		}
 	}
 }
+
+func TestManager_Name(t *testing.T) {
+	pm := Manager{Name: "web", Processes: make(map[int64]*Process)}
+	assert.Equal(t, "web#42", pm.FormatPID(42))
+
+	_, _, err := pm.Exec("NameTest", "git", "no-such-subcommand")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exec(web#1:NameTest) failed: ")
+
+	pm = Manager{Processes: make(map[int64]*Process)}
+	assert.Equal(t, "42", pm.FormatPID(42))
+	_, _, err = pm.Exec("NameTest", "git", "no-such-subcommand")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exec(1:NameTest) failed: ")
+}
+
+func TestManager_CloseStdin(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	// A reader that never reaches EOF on its own: only closing stdin lets cat finish.
+	r, w := io.Pipe()
+	defer w.Close()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := pm.ExecDirEnvStdIn(5*time.Second, "", "CloseStdin", nil, r, "cat")
+		done <- err
+	}()
+
+	eventually(t, func() bool {
+		return pm.Count() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	var pid int64
+	pm.mutex.Lock()
+	for p := range pm.Processes {
+		pid = p
+	}
+	pm.mutex.Unlock()
+
+	assert.NoError(t, pm.CloseStdin(pid))
+	// Unblock the copy from the pipe reader now that the child has gone.
+	w.Close()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cat did not exit after its stdin was closed")
+	}
+
+	assert.Equal(t, ErrNoStdin, pm.CloseStdin(pid))
+	pid = pm.Add("foo", exec.Command("foo"))
+	assert.Equal(t, ErrNoStdin, pm.CloseStdin(pid))
+}
+
+func TestManager_OlderThan(t *testing.T) {
+	now := time.Date(2019, 11, 25, 12, 0, 0, 0, time.UTC)
+	pm := Manager{Processes: make(map[int64]*Process), now: func() time.Time { return now }}
+
+	oldest := pm.Add("oldest", nil)
+	now = now.Add(5 * time.Minute)
+	old := pm.Add("old", nil)
+	now = now.Add(5 * time.Minute)
+	pm.Add("recent", nil)
+	now = now.Add(5 * time.Minute)
+
+	procs := pm.OlderThan(10 * time.Minute)
+	if assert.Len(t, procs, 1) {
+		assert.Equal(t, oldest, procs[0].PID)
+	}
+
+	procs = pm.OlderThan(7 * time.Minute)
+	if assert.Len(t, procs, 2) {
+		assert.Equal(t, oldest, procs[0].PID)
+		assert.Equal(t, old, procs[1].PID)
+	}
+
+	assert.Len(t, pm.OlderThan(0), 3)
+	assert.Empty(t, pm.OlderThan(time.Hour))
+
+	// The results are copies
+	procs[0].SetDescription("changed")
+	assert.Equal(t, "oldest", pm.Processes[oldest].CurrentDescription())
+}
+
+func TestManager_Kill(t *testing.T) {
+	pm := NewManager()
+
+	h, err := pm.Start("Kill", "sleep", []string{"5"})
+	assert.NoError(t, err)
+	assert.NoError(t, pm.Kill(h.PID()))
+	assert.Equal(t, 0, pm.Count())
+
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		t.Fatal("process was not killed")
+	}
+	_, _, err = h.Wait()
+	assert.Error(t, err)
+
+	assert.NoError(t, pm.Kill(h.PID()), "killing an unknown PID is a no-op")
+}
+
+func TestManager_NotStarted(t *testing.T) {
+	pm := NewManager()
+
+	pid := pm.Add("NotStarted", exec.Command("git", "--version"))
+	assert.Equal(t, ErrNotStarted, pm.Kill(pid))
+	assert.Equal(t, ErrNotStarted, pm.Terminate(pid, time.Second))
+	assert.Equal(t, ErrNotStarted, pm.Signal(pid, os.Interrupt))
+	assert.Equal(t, ErrNotStarted, pm.Pause(pid))
+	assert.Equal(t, 1, pm.Count(), "a process which was not killed stays tracked")
+
+	assert.Equal(t, ErrNotFound, pm.Signal(pid+1, os.Interrupt))
+	assert.NoError(t, pm.KillAll(), "processes which were not started are only forgotten")
+	assert.Equal(t, 0, pm.Count())
+}
+
+func TestManager_WaitIdle(t *testing.T) {
+	pm := newFakeManager()
+	assert.NoError(t, pm.WaitIdle(context.Background()), "an idle manager is idle at once")
+
+	var handles []*Handle
+	for i := 0; i < 3; i++ {
+		h, err := pm.Start("Spew", "spew", []string{"1", strconv.Itoa(100 * i)})
+		assert.NoError(t, err)
+		handles = append(handles, h)
+	}
+	assert.NoError(t, pm.WaitIdle(context.Background()))
+	assert.Equal(t, 0, pm.Count())
+	for _, h := range handles {
+		select {
+		case <-h.Done():
+		case <-time.After(time.Second):
+			t.Fatal("WaitIdle returned before the processes finished")
+		}
+	}
+
+	h, err := pm.Start("Hang", "hang", nil)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pm.WaitIdle(ctx))
+	assert.NoError(t, pm.Kill(h.PID()))
+	assert.NoError(t, pm.WaitIdle(context.Background()))
+	<-h.Done()
+}
+
+func TestNewManagerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pm := NewManagerContext(ctx)
+	pm.SetCommandFactory(fakeExecCommand)
+
+	h, err := pm.Start("Hang", "hang", nil)
+	assert.NoError(t, err)
+	cancel()
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the process was not killed when the context was canceled")
+	}
+	_, _, err = h.Wait()
+	assert.Error(t, err)
+
+	// Close marks the manager as closed before killing its processes
+	_, err = pm.Start("Echo", "echo", nil)
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, 0, pm.Count())
+
+	// Closing the manager stops watching a context which is never done
+	pm = NewManagerContext(context.Background())
+	n := runtime.NumGoroutine()
+	assert.NoError(t, pm.Close())
+	assert.NoError(t, pm.Close())
+	eventually(t, func() bool {
+		return runtime.NumGoroutine() < n
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestManager_KillOSPID(t *testing.T) {
+	pm := newFakeManager()
+
+	h, err := pm.Start("Hang", "hang", nil)
+	assert.NoError(t, err)
+	pm.mutex.Lock()
+	ospid := pm.Processes[h.PID()].Cmd.Process.Pid
+	pm.mutex.Unlock()
+
+	// Never kill what we don't own
+	assert.Equal(t, ErrNotFound, pm.KillOSPID(os.Getpid()))
+	assert.Equal(t, 1, pm.Count())
+
+	assert.NoError(t, pm.KillOSPID(ospid))
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the process was not killed")
+	}
+	assert.Equal(t, 0, pm.Count())
+	assert.Equal(t, ErrNotFound, pm.KillOSPID(ospid))
+}
+
+func TestManager_Close(t *testing.T) {
+	pm := NewManager()
+
+	var handles []*Handle
+	for i := 0; i < 3; i++ {
+		h, err := pm.Start("Close", "sleep", []string{"5"})
+		assert.NoError(t, err)
+		handles = append(handles, h)
+	}
+
+	assert.NoError(t, pm.Close())
+	assert.Equal(t, 0, pm.Count())
+	for _, h := range handles {
+		select {
+		case <-h.Done():
+		case <-time.After(time.Second):
+			t.Fatal("process was not killed by Close")
+		}
+	}
+
+	_, _, err := pm.Exec("AfterClose", "git", "--version")
+	assert.Equal(t, ErrClosed, err)
+	_, err = pm.Start("AfterClose", "git", []string{"--version"})
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestManager_SetDescription(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	pid := pm.Add("import", exec.Command("foo"))
+	assert.Equal(t, "import", pm.Processes[pid].Description)
+	assert.NoError(t, pm.SetDescription(pid, "import: cloning"))
+	assert.Equal(t, "import: cloning", pm.Processes[pid].CurrentDescription())
+	assert.Equal(t, "import: cloning", pm.Processes[pid].Description)
+
+	// The unlocked update leaves the field alone but shows in the copies
+	pm.Processes[pid].SetDescription("import: migrating issues")
+	assert.Equal(t, "import: cloning", pm.Processes[pid].Description)
+	procs := pm.OlderThan(0)
+	if assert.Len(t, procs, 1) {
+		assert.Equal(t, "import: migrating issues", procs[0].CurrentDescription())
+		assert.Equal(t, "import: migrating issues", procs[0].Description)
+	}
+
+	pm.Remove(pid)
+	assert.Equal(t, ErrNotFound, pm.SetDescription(pid, "gone"))
+}
+
+func TestProcess_StartedAt(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	pid := pm.Add("import", exec.Command("foo"))
+	pm.mutex.Lock()
+	proc := pm.Processes[pid]
+	pm.mutex.Unlock()
+	start := proc.StartedAt()
+	assert.False(t, start.IsZero())
+
+	// Run with -race: reading the start time doesn't race with description updates
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			proc.SetDescription(fmt.Sprintf("import: phase %d", i))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, start, proc.StartedAt())
+		_ = proc.Start
+	}
+	<-done
+	assert.Equal(t, "import: phase 999", proc.CurrentDescription())
+}
+
+func BenchmarkProcess_SetDescription(b *testing.B) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	for i := 0; i < 100; i++ {
+		pm.Add("other", exec.Command("foo"))
+	}
+	proc := pm.Processes[pm.Add("import", exec.Command("foo"))]
+
+	// The admin page keeps taking snapshots while the import reports its progress.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				pm.OlderThan(0)
+			}
+		}
+	}()
+
+	phases := []string{"import: cloning", "import: migrating issues", "import: migrating pull requests"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			proc.SetDescription(phases[i%len(phases)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"io"
+)
+
+// newlineWriter converts CRLF line endings to LF while streaming to w.
+// A trailing CR is held back until the next write tells whether it starts a CRLF.
+type newlineWriter struct {
+	w         io.Writer
+	pendingCR bool
+	buf       []byte
+}
+
+func (nw *newlineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	nw.buf = nw.buf[:0]
+	if nw.pendingCR {
+		nw.pendingCR = false
+		if p[0] != '\n' {
+			nw.buf = append(nw.buf, '\r')
+		}
+	}
+	for {
+		i := bytes.IndexByte(p, '\r')
+		if i < 0 {
+			nw.buf = append(nw.buf, p...)
+			break
+		}
+		nw.buf = append(nw.buf, p[:i]...)
+		p = p[i+1:]
+		if len(p) == 0 {
+			nw.pendingCR = true
+			break
+		}
+		if p[0] != '\n' {
+			nw.buf = append(nw.buf, '\r')
+		}
+	}
+	if _, err := nw.w.Write(nw.buf); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Flush writes a held back trailing CR.
+func (nw *newlineWriter) Flush() error {
+	if !nw.pendingCR {
+		return nil
+	}
+	nw.pendingCR = false
+	_, err := nw.w.Write([]byte{'\r'})
+	return err
+}
+
+// prefixWriter writes prefix at the start of every line streamed to w.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+	buf     []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	pw.buf = pw.buf[:0]
+	for len(p) > 0 {
+		if !pw.midLine {
+			pw.buf = append(pw.buf, pw.prefix...)
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			pw.buf = append(pw.buf, p...)
+			pw.midLine = true
+			break
+		}
+		pw.buf = append(pw.buf, p[:i+1]...)
+		p = p[i+1:]
+		pw.midLine = false
+	}
+	if _, err := pw.w.Write(pw.buf); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewlineWriter(t *testing.T) {
+	for _, tc := range []struct {
+		chunks   []string
+		expected string
+	}{
+		{[]string{"a\r\nb\nc\r\n"}, "a\nb\nc\n"},
+		{[]string{"a\r", "\nb"}, "a\nb"},
+		{[]string{"a\r", "b\r"}, "a\rb\r"},
+		{[]string{"50%\r100%\r\n"}, "50%\r100%\n"},
+		{[]string{"a\r", "", "\n"}, "a\n"},
+		{[]string{"\r\r\n"}, "\r\n"},
+	} {
+		buf := new(bytes.Buffer)
+		nw := &newlineWriter{w: buf}
+		for _, chunk := range tc.chunks {
+			n, err := nw.Write([]byte(chunk))
+			assert.NoError(t, err)
+			assert.Equal(t, len(chunk), n)
+		}
+		assert.NoError(t, nw.Flush())
+		assert.Equal(t, tc.expected, buf.String(), "%q", tc.chunks)
+	}
+}
+
+func TestWithNormalizeNewlines(t *testing.T) {
+	pm := newFakeManager()
+
+	stdout, _, err := pm.ExecWithOptions("Newlines", "echo", []string{"a\r\nb\nc\r\n"}, WithNormalizeNewlines())
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb\nc\n", stdout)
+
+	_, stderr, err := pm.ExecWithOptions("Newlines", "fail", []string{"1", "fatal:\r\nboom\r\n"}, WithNormalizeNewlines())
+	assert.Error(t, err)
+	assert.Equal(t, "fatal:\nboom\n", stderr)
+
+	streamed := new(bytes.Buffer)
+	_, _, err = pm.ExecWithOptions("Newlines", "echo", []string{"a\r\nb\r"}, WithStdoutWriter(streamed), WithNormalizeNewlines())
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb\r", streamed.String(), "the streamed output must be normalized and flushed")
+
+	stdout, _, err = pm.ExecWithOptions("Newlines", "echo", []string{"a\r\nb\n"})
+	assert.NoError(t, err)
+	assert.Equal(t, "a\r\nb\n", stdout, "the output must be untouched by default")
+}
+
+func TestPrefixWriter(t *testing.T) {
+	for _, tc := range []struct {
+		chunks   []string
+		expected string
+	}{
+		{[]string{"a\nb\n"}, "> a\n> b\n"},
+		{[]string{"a", "b\nc", "\n"}, "> ab\n> c\n"},
+		{[]string{"\n\n"}, "> \n> \n"},
+		{[]string{"a\n", "", "b"}, "> a\n> b"},
+	} {
+		buf := new(bytes.Buffer)
+		pw := &prefixWriter{w: buf, prefix: []byte("> ")}
+		for _, chunk := range tc.chunks {
+			n, err := pw.Write([]byte(chunk))
+			assert.NoError(t, err)
+			assert.Equal(t, len(chunk), n)
+		}
+		assert.Equal(t, tc.expected, buf.String(), "%q", tc.chunks)
+	}
+}
+
+func TestWithLinePrefix(t *testing.T) {
+	pm := newFakeManager()
+
+	streamed := new(bytes.Buffer)
+	stdout, stderr, err := pm.ExecWithOptions("Interleave", "interleave", []string{"2"}, WithStdoutWriter(streamed), WithLinePrefix("[fetch] "))
+	assert.NoError(t, err)
+	assert.Equal(t, "[fetch] out 1\n[fetch] out 2\n", streamed.String())
+	assert.Empty(t, stdout)
+	assert.Equal(t, "err 1\nerr 2\n", stderr, "the captured output must not be prefixed")
+
+	streamed.Reset()
+	err = pm.ExecStream(context.Background(), streamed, nil, "Echo", "echo", []string{"a\nb"}, WithLinePrefix("[echo] "))
+	assert.NoError(t, err)
+	assert.Equal(t, "[echo] a\n[echo] b", streamed.String())
+}
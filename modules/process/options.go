@@ -0,0 +1,538 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RunOption configures how a command is run.
+type RunOption func(*runOptions)
+
+type runOptions struct {
+	ctx     context.Context
+	timeout time.Duration
+	dir     string
+	env     []string
+	stdin   io.Reader
+	stdout  io.Writer // streams stdout instead of capturing it
+
+	absoluteDeadline time.Time // bounds the context of the command, see WithAbsoluteDeadline
+	cleanEnv         bool      // env was built from WithCleanEnv
+
+	minimalPath []string // overrides the PATH of the environment
+	linePrefix  string   // of the streamed lines
+
+	progress       ProgressFunc // see WithProgress
+	progressParser ProgressParser
+
+	stdoutBuffer, stderrBuffer *bytes.Buffer // owned by the caller
+
+	maxExtension time.Duration
+	heartbeat    time.Duration
+
+	startupTimeout time.Duration
+
+	stdinArgs     []string
+	stdinArgsFlag string
+	bufferStdin   bool  // see WithBufferedStdin
+	stdinLimit    int64 // see WithStdinLimit
+
+	drainDelay time.Duration // see WithPostExitDrainDelay
+
+	requestID         string
+	category          Category
+	key               string
+	dedupKey          string
+	afterStart        func(p *Process)
+	onFinish          []func(stdout, stderr string, err error)
+	onExit            func() // see withOnExit
+	pidFile           string
+	stderrCleaner     func(string) string
+	failOnStderr      bool
+	stderrToStdout    bool
+	normalizeNewlines bool
+	inheritStdio      bool
+	reaperExempt      bool
+	watchdogExempt    bool
+	recordEnv         bool
+
+	chroot     string
+	credential *Credential
+	rlimits    []rlimit
+
+	diagnosticTransform DiagnosticTransform
+
+	attempt int // of ExecRetry
+
+	err error // set by options given invalid values
+}
+
+// defaultRunOptions returns the options of a command before any RunOption is applied.
+func defaultRunOptions() runOptions {
+	return runOptions{timeout: -1, maxExtension: DefaultMaxExtension}
+}
+
+// WithTimeout sets the timeout of the command, -1 means the default timeout of its
+// category, see SetDefaultTimeout.
+func WithTimeout(timeout time.Duration) RunOption {
+	return func(o *runOptions) {
+		o.timeout = timeout
+	}
+}
+
+// Timeouts are the timeouts of a command, e.g. for a mirror sync which must connect
+// quickly but may then transfer for long.
+type Timeouts struct {
+	// Startup kills the command if it hasn't produced any output, on stdout or stderr,
+	// within it, so it only suits commands reporting progress, e.g. git with --progress.
+	// It doesn't apply with WithInheritStdio, whose output isn't seen.
+	Startup time.Duration
+	// Total is the timeout of the command, as set by WithTimeout.
+	Total time.Duration
+}
+
+// WithTimeouts sets the timeouts of the command. A zero timeout is left unchanged:
+// no startup timeout and DefaultTimeout by default.
+func WithTimeouts(t Timeouts) RunOption {
+	return func(o *runOptions) {
+		if t.Total != 0 {
+			o.timeout = t.Total
+		}
+		if t.Startup != 0 {
+			o.startupTimeout = t.Startup
+		}
+	}
+}
+
+// WithMaxExtension sets how far past its timeout the deadline of the command can be
+// extended by Process.ExtendDeadline or WithHeartbeat, DefaultMaxExtension by default.
+func WithMaxExtension(max time.Duration) RunOption {
+	return func(o *runOptions) {
+		o.maxExtension = max
+	}
+}
+
+// WithHeartbeat extends the deadline of the command to d from now every time it
+// writes some output, so that it is only killed once it has stalled for the
+// timeout or d, whichever is later.
+func WithHeartbeat(d time.Duration) RunOption {
+	return func(o *runOptions) {
+		o.heartbeat = d
+	}
+}
+
+// WithContext sets a parent context: canceling it kills the command.
+func WithContext(ctx context.Context) RunOption {
+	return func(o *runOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithAbsoluteDeadline kills the command at t, whatever its timeouts, extensions and
+// heartbeat, e.g. so that a batch of commands all finish within a maintenance window.
+// It is reported as a timeout. The earliest of several deadlines applies.
+func WithAbsoluteDeadline(t time.Time) RunOption {
+	return func(o *runOptions) {
+		if o.absoluteDeadline.IsZero() || t.Before(o.absoluteDeadline) {
+			o.absoluteDeadline = t
+		}
+	}
+}
+
+// WithDir sets the working directory of the command.
+func WithDir(dir string) RunOption {
+	return func(o *runOptions) {
+		o.dir = dir
+	}
+}
+
+// The environment of a command is set by the last of these options, each building
+// on the environment set by the previous ones for WithMergedEnv:
+//
+//	WithInheritedEnv()    the environment of Gitea, which is the default
+//	WithCleanEnv()        an empty environment
+//	WithEnv(env)          exactly env, or the environment of Gitea if env is nil
+//	WithMergedEnv(vars)   the environment so far, with vars added or overriding it
+//	WithEnvFile(path)     the environment so far, with the variables of the file merged
+//
+// WithMinimalPath then overrides the PATH of the environment, whichever it is.
+
+// WithInheritedEnv makes the command inherit the environment of Gitea.
+func WithInheritedEnv() RunOption {
+	return func(o *runOptions) {
+		o.env, o.cleanEnv = nil, false
+	}
+}
+
+// WithCleanEnv runs the command with an empty environment.
+func WithCleanEnv() RunOption {
+	return func(o *runOptions) {
+		o.env, o.cleanEnv = []string{}, true
+	}
+}
+
+// WithEnv sets the environment of the command. A nil env inherits the environment of Gitea.
+func WithEnv(env []string) RunOption {
+	return func(o *runOptions) {
+		o.env, o.cleanEnv = env, false
+	}
+}
+
+// WithMergedEnv adds the KEY=value vars to the environment set so far, the
+// inherited one by default, replacing the variables with the same keys.
+func WithMergedEnv(vars ...string) RunOption {
+	return func(o *runOptions) {
+		base := o.env
+		if base == nil {
+			base = os.Environ()
+		}
+		o.env = mergeEnv(base, vars)
+	}
+}
+
+// mergeEnv returns base with vars appended, a variable replacing the previous one with the same key.
+func mergeEnv(base, vars []string) []string {
+	merged := make([]string, 0, len(base)+len(vars))
+	index := make(map[string]int, len(base)+len(vars))
+	for _, list := range [][]string{base, vars} {
+		for _, kv := range list {
+			key := kv
+			if i := strings.IndexByte(kv, '='); i >= 0 {
+				key = kv[:i]
+			}
+			if i, ok := index[key]; ok {
+				merged[i] = kv
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// WithMinimalPath sets the PATH of the command to only dirs, whatever its environment,
+// so that hooks don't depend on, nor run binaries from, the PATH of the operator.
+// Without dirs, it is the directory of git and the system directories.
+// The command itself is still looked up in the PATH of Gitea.
+func WithMinimalPath(dirs ...string) RunOption {
+	if len(dirs) == 0 {
+		dirs = defaultMinimalPath()
+	}
+	return func(o *runOptions) {
+		o.minimalPath = dirs
+	}
+}
+
+// defaultMinimalPath returns the directory of git followed by the system directories.
+func defaultMinimalPath() []string {
+	var dirs []string
+	if git, err := exec.LookPath("git"); err == nil {
+		dirs = append(dirs, filepath.Dir(git))
+	}
+	return append(dirs, systemPathDirs()...)
+}
+
+// withPath returns env, the environment of Gitea if nil, with PATH set to dirs.
+func withPath(env, dirs []string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	path := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		// Variable names are case-insensitive on Windows, where it is usually "Path".
+		if i := strings.IndexByte(kv, '='); i >= 0 && strings.EqualFold(kv[:i], "PATH") {
+			continue
+		}
+		path = append(path, kv)
+	}
+	return append(path, "PATH="+strings.Join(dirs, string(os.PathListSeparator)))
+}
+
+// WithStdin feeds r to the stdin of the command.
+func WithStdin(r io.Reader) RunOption {
+	return func(o *runOptions) {
+		o.stdin = r
+	}
+}
+
+// WithStdoutWriter streams the stdout of the command to w as it is produced,
+// instead of capturing it. The returned stdout is then empty.
+func WithStdoutWriter(w io.Writer) RunOption {
+	return func(o *runOptions) {
+		o.stdout = w
+	}
+}
+
+// WithStdoutBuffer captures the stdout of the command into buf, e.g. to reuse a buffer
+// between commands run in a loop, instead of a buffer of the manager. The output is
+// appended to buf, which the caller owns and must reset if needed. To spare copying it,
+// the returned stdout is then empty, unlike the Stdout of an ExecError. It has no effect
+// along with WithStdoutWriter.
+func WithStdoutBuffer(buf *bytes.Buffer) RunOption {
+	return func(o *runOptions) {
+		o.stdoutBuffer = buf
+	}
+}
+
+// WithStderrBuffer is WithStdoutBuffer for stderr. It has no effect along with
+// WithStderrToStdout.
+func WithStderrBuffer(buf *bytes.Buffer) RunOption {
+	return func(o *runOptions) {
+		o.stderrBuffer = buf
+	}
+}
+
+// WithPostExitDrainDelay bounds how long the outputs of the command are still read once
+// it has exited to d, e.g. to capture what a helper forked by a hook writes to the
+// inherited stderr just after the hook exits, without waiting for the helper to exit.
+// By default, they are read for as long as such children keep them open, except for
+// killed commands, whose outputs are read for a second at most.
+func WithPostExitDrainDelay(d time.Duration) RunOption {
+	return func(o *runOptions) {
+		o.drainDelay = d
+	}
+}
+
+// WithLinePrefix writes prefix at the start of every line streamed to the writer of
+// WithStdoutWriter or ExecStream, e.g. "[fetch] " to tell commands apart in a shared
+// log. The captured outputs, like stderr, are left as is for parsing.
+func WithLinePrefix(prefix string) RunOption {
+	return func(o *runOptions) {
+		o.linePrefix = prefix
+	}
+}
+
+// WithStderrCleaner makes the handle also provide the stderr of the command as
+// cleaned by clean, through Handle.CleanedStderr and ExecError.CleanedStderr.
+// The raw stderr is still returned as is. A nil clean means CleanStderr.
+func WithStderrCleaner(clean func(string) string) RunOption {
+	return func(o *runOptions) {
+		if clean == nil {
+			clean = CleanStderr
+		}
+		o.stderrCleaner = clean
+	}
+}
+
+// WithStderrToStdout merges the stderr of the command into its stdout, like 2>&1:
+// the returned stdout holds both in the order they were written and stderr is empty.
+func WithStderrToStdout() RunOption {
+	return func(o *runOptions) {
+		o.stderrToStdout = true
+	}
+}
+
+// WithFailOnStderr makes a command that exits successfully but wrote anything to
+// its stderr fail with an ExecError wrapping ErrUnexpectedStderr, for strict
+// plumbing calls. It is opt-in since many git commands warn on stderr.
+func WithFailOnStderr() RunOption {
+	return func(o *runOptions) {
+		o.failOnStderr = true
+	}
+}
+
+// WithRequestID ties the command to a request, e.g. the ID set by an HTTP middleware:
+// it is shown alongside the description in the logs, history and errors of the process.
+func WithRequestID(id string) RunOption {
+	return func(o *runOptions) {
+		o.requestID = id
+	}
+}
+
+// WithStdinArgs appends items to the arguments of the command, unless the resulting
+// command line would exceed what the platform allows. In that case the items are
+// written to stdin, one per line, and stdinFlag (e.g. "--stdin") is appended instead.
+// It is meant for commands like git rev-list or git cat-file --batch that accept
+// their input both ways, and cannot be combined with WithStdin.
+func WithStdinArgs(items []string, stdinFlag string) RunOption {
+	return func(o *runOptions) {
+		o.stdinArgs = items
+		o.stdinArgsFlag = stdinFlag
+	}
+}
+
+// applyStdinArgs returns the arguments to run the command with and sets up stdin if needed.
+func (o *runOptions) applyStdinArgs(cmdName string, args []string) ([]string, error) {
+	if o.stdin != nil {
+		return nil, errors.New("WithStdinArgs cannot be combined with WithStdin")
+	}
+
+	size := len(cmdName) + 1
+	for _, arg := range args {
+		size += len(arg) + 1
+	}
+	for _, item := range o.stdinArgs {
+		size += len(item) + 1
+	}
+	if size <= maxCommandLineLength {
+		return append(args[:len(args):len(args)], o.stdinArgs...), nil
+	}
+
+	o.stdin = strings.NewReader(strings.Join(o.stdinArgs, "\n") + "\n")
+	if o.stdinArgsFlag == "" {
+		return args, nil
+	}
+	return append(args[:len(args):len(args)], o.stdinArgsFlag), nil
+}
+
+// WithAfterStart sets a function called synchronously once the command has started
+// and is tracked, before waiting for it. p.Cmd.Process holds the OS process,
+// e.g. to write a pidfile or attach a profiler. fn must return promptly: the
+// command is not waited for, nor its timeout enforced by the caller, until it does.
+func WithAfterStart(fn func(p *Process)) RunOption {
+	return func(o *runOptions) {
+		o.afterStart = fn
+	}
+}
+
+// WithOnFinish calls fn with the outputs and the error of the command once it has
+// exited, in the goroutine waiting for it and before the process is removed, e.g.
+// to update a cache. Several WithOnFinish are all called, in the order they were given.
+func WithOnFinish(fn func(stdout, stderr string, err error)) RunOption {
+	return func(o *runOptions) {
+		o.onFinish = append(o.onFinish, fn)
+	}
+}
+
+// WithPidFile writes the OS PID of the command to path once it has started, for
+// external supervisors, and removes the file once it has exited. Failing to write
+// the file is logged, the command still runs.
+func WithPidFile(path string) RunOption {
+	return func(o *runOptions) {
+		o.pidFile = path
+	}
+}
+
+// WithNormalizeNewlines converts CRLF line endings to LF in the captured stdout and stderr,
+// and in the stdout streamed with WithStdoutWriter.
+func WithNormalizeNewlines() RunOption {
+	return func(o *runOptions) {
+		o.normalizeNewlines = true
+	}
+}
+
+// WithInheritStdio connects the command to the stdin, stdout and stderr of Gitea
+// instead of capturing its outputs, e.g. to let git progress render in a terminal
+// for CLI commands. The returned outputs are empty. The command is still tracked
+// and its timeout applies. A reader given with WithStdin takes precedence for stdin.
+func WithInheritStdio() RunOption {
+	return func(o *runOptions) {
+		o.inheritStdio = true
+	}
+}
+
+// WithChroot runs the command with dir as its root directory on Unix. This requires
+// Gitea to run as root or with CAP_SYS_CHROOT, and dir to contain everything the
+// command needs, starting with the binary itself. It fails on Windows.
+func WithChroot(dir string) RunOption {
+	return func(o *runOptions) {
+		if fi, err := os.Stat(dir); err != nil {
+			o.err = fmt.Errorf("invalid chroot: %v", err)
+		} else if !fi.IsDir() {
+			o.err = fmt.Errorf("invalid chroot: %s is not a directory", dir)
+		}
+		o.chroot = dir
+	}
+}
+
+// Credential is the user and groups a command runs as.
+type Credential struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32 // supplementary groups
+}
+
+// WithCredential runs the command as the given user, group and supplementary groups
+// on Unix, e.g. to drop privileges when running untrusted hooks. Unless Gitea runs as
+// root, only its own uid and gid can be used, without supplementary groups: the command
+// keeps those of Gitea. It fails on Windows.
+func WithCredential(uid, gid uint32, groups []uint32) RunOption {
+	return func(o *runOptions) {
+		o.credential = &Credential{
+			UID:    uid,
+			GID:    gid,
+			Groups: append([]uint32(nil), groups...),
+		}
+	}
+}
+
+// The resources limited by rlimit.
+const (
+	rlimitCPU = iota
+	rlimitFileSize
+)
+
+// rlimit is a resource limit set before the command is executed, see startWithRlimits.
+type rlimit struct {
+	resource   int
+	soft, hard uint64
+}
+
+// WithCPUTimeLimit caps the CPU time of the command to seconds through RLIMIT_CPU on Unix:
+// the kernel sends it SIGXCPU once the limit is reached, then SIGKILL a second later on
+// Linux. Unlike a timeout, this contains CPU-bound abuse whatever the load of the server.
+// The command is started through the executable of Gitea, which sets the limit before
+// executing it, capped to the hard limit of Gitea. It can't be combined with WithChroot,
+// and fails on Windows.
+func WithCPUTimeLimit(seconds uint64) RunOption {
+	return func(o *runOptions) {
+		o.setRlimit(rlimit{resource: rlimitCPU, soft: seconds, hard: seconds + 1})
+	}
+}
+
+// WithFileSizeLimit caps the size of the files the command writes to bytes through
+// RLIMIT_FSIZE on Unix: the kernel sends it SIGXFSZ when it tries to grow a file past
+// the limit. Unlike the output budget, which only covers stdout and stderr, this protects
+// the disk from e.g. runaway bundle creation. The limit is set like WithCPUTimeLimit.
+// It fails on Windows.
+func WithFileSizeLimit(bytes uint64) RunOption {
+	return func(o *runOptions) {
+		o.setRlimit(rlimit{resource: rlimitFileSize, soft: bytes, hard: bytes})
+	}
+}
+
+// setRlimit adds limit, replacing any previous limit of the same resource.
+func (o *runOptions) setRlimit(limit rlimit) {
+	for i := range o.rlimits {
+		if o.rlimits[i].resource == limit.resource {
+			o.rlimits[i] = limit
+			return
+		}
+	}
+	o.rlimits = append(o.rlimits, limit)
+}
+
+// configure applies the platform specific options to cmd.
+func (o *runOptions) configure(cmd *exec.Cmd) error {
+	if o.minimalPath != nil {
+		cmd.Env = withPath(cmd.Env, o.minimalPath)
+	}
+	if o.chroot != "" {
+		// The executable of Gitea, which sets the limits, is out of reach of the command.
+		if len(o.rlimits) > 0 {
+			return errors.New("resource limits can't be set on a chrooted command")
+		}
+		if err := setChroot(cmd, o.chroot); err != nil {
+			return err
+		}
+	}
+	if o.credential != nil {
+		if err := setCredential(cmd, o.credential); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithStdinArgs(t *testing.T) {
+	pm := newFakeManager()
+
+	items := make([]string, 100000)
+	for i := range items {
+		items[i] = fmt.Sprintf("%040x", i)
+	}
+
+	stdout, stderr, err := pm.ExecWithOptions("ManyArgs", "count", nil, WithStdinArgs(items, "--stdin"))
+	assert.NoError(t, err, stderr)
+	assert.Equal(t, "stdin 100000", stdout)
+
+	stdout, stderr, err = pm.ExecWithOptions("FewArgs", "count", nil, WithStdinArgs(items[:10], "--stdin"))
+	assert.NoError(t, err, stderr)
+	assert.Equal(t, "args 10", stdout)
+
+	_, _, err = pm.ExecWithOptions("Conflict", "count", nil, WithStdinArgs(items, "--stdin"), WithStdin(strings.NewReader("")))
+	assert.Error(t, err)
+}
+
+func TestWithAfterStart(t *testing.T) {
+	pm := newFakeManager()
+
+	var started *Process
+	h, err := pm.Start("AfterStart", "echo", nil, WithAfterStart(func(p *Process) {
+		started = p
+		assert.NotNil(t, p.Cmd.Process)
+		assert.True(t, p.Cmd.Process.Pid > 0)
+		assert.Nil(t, p.Cmd.ProcessState, "the callback must run before Wait")
+	}))
+	assert.NoError(t, err)
+	if assert.NotNil(t, started, "the callback must run before Start returns") {
+		assert.Equal(t, h.PID(), started.PID)
+		assert.Equal(t, "AfterStart", started.CurrentDescription())
+	}
+	_, _, err = h.Wait()
+	assert.NoError(t, err)
+}
+
+func TestWithInheritStdio(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	stdout, stderr, err := pm.ExecWithOptions("InheritStdio", "echo", []string{"printed to the test output"}, WithInheritStdio())
+	assert.NoError(t, err)
+	assert.Empty(t, stdout)
+	assert.Empty(t, stderr)
+
+	_, _, err = pm.ExecWithOptions("InheritStdio", "hang", nil, WithInheritStdio(), WithTimeout(100*time.Millisecond))
+	assert.Error(t, err, "the timeout must still apply")
+	assert.Len(t, pm.History(), 2)
+}
+
+func TestWithFailOnStderr(t *testing.T) {
+	pm := newFakeManager()
+
+	// Without the option, a warning doesn't fail the command
+	_, stderr, err := pm.ExecWithOptions("Warn", "fail", []string{"0", "warning: something"})
+	assert.NoError(t, err)
+	assert.Equal(t, "warning: something", stderr)
+
+	_, _, err = pm.ExecWithOptions("Warn", "fail", []string{"0", "warning: something"}, WithFailOnStderr())
+	assert.True(t, errors.Is(err, ErrUnexpectedStderr))
+	var execErr *ExecError
+	assert.True(t, errors.As(err, &execErr))
+	assert.Equal(t, 0, execErr.ExitCode)
+	assert.Equal(t, "warning: something", execErr.Stderr)
+
+	_, _, err = pm.ExecWithOptions("Quiet", "echo", []string{"hello"}, WithFailOnStderr())
+	assert.NoError(t, err)
+
+	// Real failures are still reported as such
+	_, _, err = pm.ExecWithOptions("Fail", "fail", []string{"2", "boom"}, WithFailOnStderr())
+	assert.False(t, errors.Is(err, ErrUnexpectedStderr))
+}
+
+func TestEnvOptions(t *testing.T) {
+	pm := newFakeManager()
+	os.Setenv("PROCESS_TEST_INHERITED", "gitea")
+	defer os.Unsetenv("PROCESS_TEST_INHERITED")
+
+	for name, test := range map[string]struct {
+		opts     []RunOption
+		expected string
+	}{
+		"Default":   {nil, "PROCESS_TEST_INHERITED=gitea\n"},
+		"Inherited": {[]RunOption{WithEnv([]string{"PROCESS_TEST_SET=1"}), WithInheritedEnv()}, "PROCESS_TEST_INHERITED=gitea\n"},
+		"Clean":     {[]RunOption{WithCleanEnv()}, ""},
+		"Env":       {[]RunOption{WithEnv([]string{"PROCESS_TEST_SET=1"})}, "PROCESS_TEST_SET=1\n"},
+		"NilEnv":    {[]RunOption{WithEnv(nil)}, "PROCESS_TEST_INHERITED=gitea\n"},
+		"Merged": {
+			[]RunOption{WithMergedEnv("PROCESS_TEST_SET=1", "PROCESS_TEST_INHERITED=merged")},
+			"PROCESS_TEST_INHERITED=merged\nPROCESS_TEST_SET=1\n",
+		},
+		"CleanMerged": {[]RunOption{WithCleanEnv(), WithMergedEnv("PROCESS_TEST_SET=1")}, "PROCESS_TEST_SET=1\n"},
+	} {
+		stdout, _, err := pm.ExecWithOptions(name, "env", []string{"PROCESS_TEST_INHERITED", "PROCESS_TEST_SET"}, test.opts...)
+		assert.NoError(t, err, name)
+		assert.Equal(t, test.expected, stdout, name)
+	}
+
+	assert.Equal(t, []string{"A=3", "B=2", "C"}, mergeEnv([]string{"A=1", "B=2"}, []string{"A=3", "C"}))
+}
+
+func TestWithMinimalPath(t *testing.T) {
+	pm := newFakeManager()
+
+	path := strings.Join([]string{"/opt/a", "/opt/b"}, string(os.PathListSeparator))
+	for name, opts := range map[string][]RunOption{
+		"Default":   {WithMinimalPath("/opt/a", "/opt/b")},
+		"Inherited": {WithMinimalPath("/opt/a", "/opt/b"), WithInheritedEnv()},
+		"Env":       {WithEnv([]string{"PATH=/usr/local/bin", "PROCESS_TEST_SET=1"}), WithMinimalPath("/opt/a", "/opt/b")},
+	} {
+		stdout, _, err := pm.ExecWithOptions(name, "env", []string{"PATH"}, opts...)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "PATH="+path+"\n", stdout, name)
+	}
+
+	stdout, _, err := pm.ExecWithOptions("DefaultDirs", "env", []string{"PATH"}, WithMinimalPath())
+	assert.NoError(t, err)
+	assert.Equal(t, "PATH="+strings.Join(defaultMinimalPath(), string(os.PathListSeparator))+"\n", stdout)
+	assert.Equal(t, []string{"A=1", "PATH=/bin"}, withPath([]string{"Path=/usr/bin", "A=1"}, []string{"/bin"}))
+}
+
+func TestWithStderrToStdout(t *testing.T) {
+	pm := newFakeManager()
+
+	stdout, stderr, err := pm.ExecWithOptions("Interleave", "interleave", []string{"3"})
+	assert.NoError(t, err)
+	assert.Equal(t, "out 1\nout 2\nout 3\n", stdout)
+	assert.Equal(t, "err 1\nerr 2\nerr 3\n", stderr)
+
+	stdout, stderr, err = pm.ExecWithOptions("Interleave", "interleave", []string{"3"}, WithStderrToStdout())
+	assert.NoError(t, err)
+	assert.Equal(t, "out 1\nerr 1\nout 2\nerr 2\nout 3\nerr 3\n", stdout)
+	assert.Empty(t, stderr)
+
+	stdout, _, err = pm.ExecWithOptions("Interleave", "interleave", []string{"2"}, WithStderrToStdout(), WithHeartbeat(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, "out 1\nerr 1\nout 2\nerr 2\n", stdout)
+}
+
+func TestWithOnFinish(t *testing.T) {
+	pm := newFakeManager()
+
+	var calls []string
+	_, _, err := pm.ExecWithOptions("Fail", "fail", []string{"3", "boom"},
+		WithOnFinish(func(stdout, stderr string, err error) {
+			assert.Equal(t, "boom", stderr)
+			assert.Equal(t, 3, err.(*ExecError).ExitCode)
+			assert.Equal(t, 1, pm.Count(), "the process is removed after the callbacks")
+			calls = append(calls, "first")
+		}),
+		WithOnFinish(func(stdout, stderr string, err error) {
+			calls = append(calls, "second")
+		}))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"first", "second"}, calls)
+	assert.Equal(t, 0, pm.Count())
+
+	h, err := pm.Start("Echo", "echo", []string{"hello"}, WithOnFinish(func(stdout, stderr string, err error) {
+		assert.Equal(t, "hello", stdout)
+		assert.NoError(t, err)
+		calls = append(calls, "echo")
+	}))
+	assert.NoError(t, err)
+	<-h.Done()
+	assert.Equal(t, []string{"first", "second", "echo"}, calls)
+}
+
+func TestWithPidFile(t *testing.T) {
+	pm := newFakeManager()
+
+	dir, err := ioutil.TempDir("", "pidfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "helper.pid")
+
+	var ospid int
+	h, err := pm.Start("Daemon", "hang", nil, WithPidFile(path), WithAfterStart(func(p *Process) {
+		ospid = p.Cmd.Process.Pid
+	}))
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\n", ospid), string(content))
+
+	assert.NoError(t, pm.Kill(h.PID()))
+	<-h.Done()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "the pidfile was not removed")
+
+	// A pidfile that can't be written doesn't prevent the command from running
+	stdout, _, err := pm.ExecWithOptions("Echo", "echo", []string{"hello"}, WithPidFile(filepath.Join(dir, "missing", "echo.pid")))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", stdout)
+}
+
+func TestWithDiagnosticRetry(t *testing.T) {
+	pm := newFakeManager()
+
+	transform := func(args, env []string) ([]string, []string) {
+		return []string{args[0], "verbose " + args[1]}, env
+	}
+	_, stderr, err := pm.ExecWithOptions("Fail", "fail", []string{"3", "boom"}, WithDiagnosticRetry(transform))
+	assert.Error(t, err)
+	assert.Equal(t, "boom", stderr)
+	assert.Equal(t, "verbose boom", err.(*ExecError).DiagnosticOutput)
+	assert.Equal(t, 0, pm.Count())
+
+	// The diagnostic run doesn't write to the buffers of the caller
+	var stdoutBuf, stderrBuf bytes.Buffer
+	_, _, err = pm.ExecWithOptions("Fail", "fail", []string{"3", "boom"}, WithDiagnosticRetry(transform),
+		WithStdoutBuffer(&stdoutBuf), WithStderrBuffer(&stderrBuf))
+	assert.Error(t, err)
+	assert.Equal(t, "verbose boom", err.(*ExecError).DiagnosticOutput)
+	assert.Empty(t, stdoutBuf.String())
+	assert.Equal(t, "boom", stderrBuf.String())
+
+	stdout, _, err := pm.ExecWithOptions("Echo", "echo", []string{"hello"}, WithDiagnosticRetry(func(args, env []string) ([]string, []string) {
+		t.Error("the transform was called for a successful command")
+		return args, env
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", stdout)
+
+	_, env := GitTraceTransform(nil, []string{"GIT_TRACE=0", "HOME=/tmp"})
+	assert.Equal(t, []string{"GIT_TRACE=1", "HOME=/tmp"}, env)
+}
+
+func TestWithStdoutBuffer(t *testing.T) {
+	pm := newFakeManager()
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	stdoutBuf.WriteString("kept ")
+	stdout, stderr, err := pm.ExecWithOptions("Interleave", "interleave", []string{"2"},
+		WithStdoutBuffer(&stdoutBuf), WithStderrBuffer(&stderrBuf))
+	assert.NoError(t, err)
+	assert.Empty(t, stdout, "the output is in the buffer")
+	assert.Empty(t, stderr)
+	assert.Equal(t, "kept out 1\nout 2\n", stdoutBuf.String(), "the output must be appended")
+	assert.Equal(t, "err 1\nerr 2\n", stderrBuf.String())
+
+	// The buffers are not pooled by the manager, so they can be reused
+	stderrBuf.Reset()
+	_, _, err = pm.ExecWithOptions("Fail", "fail", []string{"1", "boom"}, WithStderrBuffer(&stderrBuf))
+	assert.Equal(t, "boom", stderrBuf.String())
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "boom", execErr.Stderr, "the error must hold a copy")
+	}
+}
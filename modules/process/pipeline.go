@@ -0,0 +1,119 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ExecSpec describes a command of a Pipeline.
+type ExecSpec struct {
+	Description string
+	Name        string
+	Args        []string
+	Options     []RunOption
+}
+
+// PipelineError is the error returned by Pipeline, identifying the stage that failed first.
+type PipelineError struct {
+	Stage       int // index of the stage in the pipeline
+	Description string
+	Err         error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline stage %d (%s) failed: %v", e.Stage, e.Description, e.Err)
+}
+
+// Unwrap returns the error of the stage.
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline runs the stages like a shell pipeline, the stdout of each stage feeding
+// the stdin of the next one, and returns the stdout of the last stage. As soon as a
+// stage fails, or ctx is canceled, the whole pipeline is killed. The stages can't
+// be given WithStdin, WithStdoutWriter nor WithContext as Pipeline sets them.
+func (pm *Manager) Pipeline(ctx context.Context, stages []ExecSpec) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+		stdout   string
+	)
+	fail := func(i int, err error) {
+		once.Do(func() {
+			firstErr = &PipelineError{Stage: i, Description: stages[i].Description, Err: err}
+			cancel()
+		})
+	}
+
+	var (
+		stdin   *io.PipeReader // stdout of the previous stage
+		prevOut *io.PipeWriter
+	)
+	for i, stage := range stages {
+		opts := append(stage.Options[:len(stage.Options):len(stage.Options)], WithContext(ctx))
+		if stdin != nil {
+			opts = append(opts, WithStdin(stdin))
+		}
+		var next *io.PipeReader
+		var out *io.PipeWriter
+		if i < len(stages)-1 {
+			next, out = io.Pipe()
+			opts = append(opts, WithStdoutWriter(out))
+		}
+		if prev := prevOut; prev != nil {
+			// The copy to stdin blocks reading the previous stage, which may never
+			// write again: EOF unblocks it as soon as this stage has exited.
+			opts = append(opts, withOnExit(func() { _ = prev.Close() }))
+		}
+
+		h, err := pm.Start(stage.Description, stage.Name, stage.Args, opts...)
+		if err != nil {
+			fail(i, err)
+			// Unblock the previous stage, which is being killed.
+			if stdin != nil {
+				_ = stdin.CloseWithError(err)
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int, h *Handle, in *io.PipeReader, out *io.PipeWriter) {
+			defer wg.Done()
+			stageStdout, _, err := h.Wait()
+			// EOF for the next stage, and an error for the previous one if this
+			// stage exited without reading all of its input.
+			if out != nil {
+				_ = out.Close()
+			}
+			if in != nil {
+				_ = in.Close()
+			}
+			if err != nil {
+				fail(i, err)
+			} else if out == nil {
+				stdout = stageStdout
+			}
+		}(i, h, stdin, out)
+		stdin, prevOut = next, out
+	}
+	wg.Wait()
+	return stdout, firstErr
+}
+
+// withOnExit calls fn once the command has exited, before its outputs are drained and
+// its copy to stdin is waited for.
+func withOnExit(fn func()) RunOption {
+	return func(o *runOptions) {
+		o.onExit = fn
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_Pipeline(t *testing.T) {
+	pm := newFakeManager()
+
+	stdout, err := pm.Pipeline(context.Background(), []ExecSpec{
+		{Description: "Lines", Name: "lines", Args: []string{"3", "0"}},
+		{Description: "Count", Name: "count", Args: []string{"--stdin"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "stdin 3", stdout)
+
+	_, err = pm.Pipeline(context.Background(), []ExecSpec{
+		{Description: "Lines", Name: "lines", Args: []string{"3", "0"}},
+		{Description: "Fail", Name: "fail", Args: []string{"3", "boom"}},
+	})
+	var pipelineErr *PipelineError
+	if assert.True(t, errors.As(err, &pipelineErr)) {
+		assert.Equal(t, 1, pipelineErr.Stage)
+		assert.Equal(t, "Fail", pipelineErr.Description)
+		assert.Equal(t, 3, pipelineErr.Err.(*ExecError).ExitCode)
+	}
+	assert.Equal(t, 0, pm.Count())
+}
+
+func TestManager_PipelineCancel(t *testing.T) {
+	pm := newFakeManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := pm.Pipeline(ctx, []ExecSpec{
+			{Description: "Hang", Name: "hang"},
+			{Description: "Hang", Name: "hang"},
+		})
+		done <- err
+	}()
+
+	eventually(t, func() bool {
+		return pm.Count() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipeline was not killed when its context was canceled")
+	}
+	assert.Equal(t, 0, pm.Count())
+	assert.Equal(t, int64(2), pm.Stats().Canceled)
+}
+
+func TestManager_PipelineSilentUpstream(t *testing.T) {
+	pm := newFakeManager()
+
+	done := make(chan error)
+	go func() {
+		_, err := pm.Pipeline(context.Background(), []ExecSpec{
+			{Description: "Hang", Name: "hang"},
+			{Description: "Fail", Name: "fail", Args: []string{"1", "boom"}},
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var pipelineErr *PipelineError
+		if assert.True(t, errors.As(err, &pipelineErr)) {
+			assert.Equal(t, 1, pipelineErr.Stage)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipeline was not killed when a stage failed")
+	}
+	assert.Equal(t, 0, pm.Count())
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	benchmarkOutput = []byte("git version 2.24.0\n")
+	// benchmarkSink makes the buffers escape like they do when handed to exec.Cmd
+	benchmarkSink io.Writer
+)
+
+func TestBufferPool(t *testing.T) {
+	buf := getBuffer()
+	buf.WriteString("foo")
+	putBuffer(buf)
+	assert.Equal(t, 0, getBuffer().Len(), "buffers from the pool must be empty")
+
+	big := getBuffer()
+	big.Grow(maxPooledBufferSize + 1)
+	putBuffer(big)
+}
+
+// BenchmarkCaptureNewBuffer captures a small output the way it was done before the pool.
+func BenchmarkCaptureNewBuffer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		benchmarkSink, benchmarkSink = stdout, stderr
+		stdout.Write(benchmarkOutput)
+		_, _ = stdout.String(), stderr.String()
+	}
+}
+
+func BenchmarkCapturePooledBuffer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stdout, stderr := getBuffer(), getBuffer()
+		benchmarkSink, benchmarkSink = stdout, stderr
+		stdout.Write(benchmarkOutput)
+		_, _ = stdout.String(), stderr.String()
+		putBuffer(stdout)
+		putBuffer(stderr)
+	}
+}
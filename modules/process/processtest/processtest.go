@@ -0,0 +1,29 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package processtest provides utilities for tests running commands through a process manager.
+package processtest
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/modules/process"
+)
+
+// NewManager returns a fresh manager for a test, so that it doesn't share the processes
+// of the manager returned by process.GetManager with other tests, and a function that
+// must be deferred to kill the processes left running and close the manager, failing
+// the test if that fails. It should become a t.Cleanup once Go 1.14 is required.
+func NewManager(t testing.TB) (*process.Manager, func()) {
+	pm := process.NewManager()
+	pm.Name = t.Name()
+	return pm, func() {
+		if n := pm.Count(); n > 0 {
+			t.Logf("Killing %d processes left running", n)
+		}
+		if err := pm.Close(); err != nil {
+			t.Errorf("Unable to close the process manager: %v", err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package processtest
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/modules/process"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewManager(t *testing.T) {
+	pm, cleanup := NewManager(t)
+	assert.Equal(t, "TestNewManager", pm.Name)
+
+	stdout, _, err := pm.Exec("Version", "git", "--version")
+	assert.NoError(t, err)
+	assert.Contains(t, stdout, "git version")
+
+	// A process the test forgot about is killed by the cleanup
+	h, err := pm.Start("Leaked", "sleep", []string{"10"})
+	assert.NoError(t, err)
+	cleanup()
+	_, _, err = h.Wait()
+	assert.Error(t, err)
+	assert.Equal(t, 0, pm.Count())
+
+	_, _, err = pm.Exec("Closed", "git", "--version")
+	assert.Equal(t, process.ErrClosed, err)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxProgressLine is the length beyond which a line of output is not parsed for progress.
+const maxProgressLine = 4096
+
+// ProgressFunc is notified of the progress of a command, e.g. to update a progress bar.
+type ProgressFunc func(percent int, phase string)
+
+// ProgressParser returns the progress reported by a line of output, and whether it
+// reports any.
+type ProgressParser func(line string) (percent int, phase string, ok bool)
+
+var gitProgressPattern = regexp.MustCompile(`^(?:remote: )?([A-Za-z][A-Za-z ]*):\s+(\d{1,3})%`)
+
+// ParseGitProgress is the default ProgressParser. It parses the progress printed by git
+// with --progress, e.g. "Receiving objects:  42% (21/50), 1.00 MiB | 2.00 MiB/s"
+// gives 42 and "Receiving objects". The "remote: " prefix of the progress of the remote
+// is left out of the phase.
+func ParseGitProgress(line string) (int, string, bool) {
+	m := gitProgressPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, "", false
+	}
+	percent, err := strconv.Atoi(m[2])
+	if err != nil || percent > 100 {
+		return 0, "", false
+	}
+	return percent, strings.TrimSpace(m[1]), true
+}
+
+// WithProgress parses the stderr of the command with parse as it is produced, and calls fn
+// with the progress it reports, e.g. with git --progress. Lines are split on carriage
+// returns as well, which git uses to update its progress in place. fn is only called when
+// the progress changes, from the goroutine copying the output, so it must not block.
+// A nil parse means ParseGitProgress. Along with WithStderrToStdout, the merged output is
+// parsed.
+func WithProgress(parse ProgressParser, fn ProgressFunc) RunOption {
+	return func(o *runOptions) {
+		if parse == nil {
+			parse = ParseGitProgress
+		}
+		o.progressParser, o.progress = parse, fn
+	}
+}
+
+// progressWriter calls fn with the progress parsed from every line written to it.
+type progressWriter struct {
+	parse ProgressParser
+	fn    ProgressFunc
+
+	buf      []byte // incomplete line
+	overflow bool   // the current line exceeded maxProgressLine
+	percent  int
+	phase    string
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexAny(p, "\r\n")
+		if i < 0 {
+			pw.append(p)
+			break
+		}
+		pw.append(p[:i])
+		pw.line()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (pw *progressWriter) append(p []byte) {
+	if pw.overflow || len(pw.buf)+len(p) > maxProgressLine {
+		pw.overflow = true
+		pw.buf = pw.buf[:0]
+		return
+	}
+	pw.buf = append(pw.buf, p...)
+}
+
+func (pw *progressWriter) line() {
+	line, overflow := string(pw.buf), pw.overflow
+	pw.buf, pw.overflow = pw.buf[:0], false
+	if overflow || line == "" {
+		return
+	}
+	percent, phase, ok := pw.parse(line)
+	if !ok || (percent == pw.percent && phase == pw.phase) {
+		return
+	}
+	pw.percent, pw.phase = percent, phase
+	pw.fn(percent, phase)
+}
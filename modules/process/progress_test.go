@@ -0,0 +1,92 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGitProgress(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		percent int
+		phase   string
+		ok      bool
+	}{
+		{"Receiving objects:  42% (21/50), 1.00 MiB | 2.00 MiB/s", 42, "Receiving objects", true},
+		{"remote: Counting objects: 100% (10/10), done.", 100, "Counting objects", true},
+		{"Resolving deltas:   0% (0/3)", 0, "Resolving deltas", true},
+		{"Cloning into 'repo'...", 0, "", false},
+		{"Receiving objects: 420% (21/5)", 0, "", false},
+	} {
+		percent, phase, ok := ParseGitProgress(tc.line)
+		assert.Equal(t, tc.ok, ok, tc.line)
+		assert.Equal(t, tc.percent, percent, tc.line)
+		assert.Equal(t, tc.phase, phase, tc.line)
+	}
+}
+
+type progressUpdate struct {
+	percent int
+	phase   string
+}
+
+func TestWithProgress(t *testing.T) {
+	var output strings.Builder
+	output.WriteString("Cloning into 'repo'...\n")
+	for i := 0; i <= 10; i++ {
+		fmt.Fprintf(&output, "Receiving objects: %3d%% (%d/10)\r", i*10, i)
+	}
+	output.WriteString("Receiving objects: 100% (10/10), done.\n")
+	output.WriteString("Resolving deltas:  50% (1/2)\rResolving deltas: 100% (2/2)\rResolving deltas: 100% (2/2), done.\n")
+
+	// Fed in chunks splitting the lines
+	var updates []progressUpdate
+	pw := &progressWriter{parse: ParseGitProgress, fn: func(percent int, phase string) {
+		updates = append(updates, progressUpdate{percent, phase})
+	}}
+	for data := output.String(); len(data) > 0; {
+		n := 7
+		if n > len(data) {
+			n = len(data)
+		}
+		_, _ = pw.Write([]byte(data[:n]))
+		data = data[n:]
+	}
+	if assert.Len(t, updates, 13) {
+		for i := 0; i <= 10; i++ {
+			assert.Equal(t, progressUpdate{i * 10, "Receiving objects"}, updates[i])
+		}
+		assert.Equal(t, []progressUpdate{{50, "Resolving deltas"}, {100, "Resolving deltas"}}, updates[11:])
+	}
+
+	pm := newFakeManager()
+	updates = updates[:0]
+	_, stderr, err := pm.ExecWithOptions("Clone", "fail", []string{"0", output.String()},
+		WithProgress(nil, func(percent int, phase string) {
+			updates = append(updates, progressUpdate{percent, phase})
+		}))
+	assert.NoError(t, err)
+	assert.Equal(t, output.String(), stderr, "stderr is still captured")
+	assert.Len(t, updates, 13)
+
+	updates = updates[:0]
+	_, _, err = pm.ExecWithOptions("Custom", "fail", []string{"0", "step 1/4\nstep 2/4\n"},
+		WithProgress(func(line string) (int, string, bool) {
+			var step, steps int
+			if _, err := fmt.Sscanf(line, "step %d/%d", &step, &steps); err != nil {
+				return 0, "", false
+			}
+			return 100 * step / steps, "step", true
+		}, func(percent int, phase string) {
+			updates = append(updates, progressUpdate{percent, phase})
+		}))
+	assert.NoError(t, err)
+	assert.Equal(t, []progressUpdate{{25, "step"}, {50, "step"}}, updates)
+}
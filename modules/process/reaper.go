@@ -0,0 +1,94 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// reaper periodically kills the processes running for longer than a maximum lifetime.
+type reaper struct {
+	maxLifetime time.Duration
+	interval    time.Duration
+	stop        chan struct{}
+}
+
+// WithExemptFromReaper keeps the command from being killed by the reaper started with
+// StartReaper, e.g. for the initial import of a huge repository. Its own timeout applies.
+func WithExemptFromReaper() RunOption {
+	return func(o *runOptions) {
+		o.reaperExempt = true
+	}
+}
+
+// WithExemptFromWatchdog keeps the command from being logged by the watchdog started
+// with StartWatchdog, e.g. for a job known to run for long.
+func WithExemptFromWatchdog() RunOption {
+	return func(o *runOptions) {
+		o.watchdogExempt = true
+	}
+}
+
+// StartReaper starts a goroutine that kills, every interval, the processes still running
+// after maxLifetime, as a safety net against stuck processes whatever their timeout.
+// Processes run WithExemptFromReaper are spared, as well as those added with Add that
+// have not been started. Any previously started reaper is stopped.
+func (pm *Manager) StartReaper(maxLifetime, interval time.Duration) {
+	r := &reaper{
+		maxLifetime: maxLifetime,
+		interval:    interval,
+		stop:        make(chan struct{}),
+	}
+
+	pm.mutex.Lock()
+	if pm.reaper != nil {
+		close(pm.reaper.stop)
+	}
+	pm.reaper = r
+	pm.mutex.Unlock()
+
+	go r.run(pm)
+}
+
+// StopReaper stops the reaper started by StartReaper, if any.
+func (pm *Manager) StopReaper() {
+	pm.mutex.Lock()
+	if pm.reaper != nil {
+		close(pm.reaper.stop)
+		pm.reaper = nil
+	}
+	pm.mutex.Unlock()
+}
+
+func (r *reaper) run(pm *Manager) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pm.reap(r.maxLifetime)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// reap kills the processes running for longer than maxLifetime which aren't exempt.
+func (pm *Manager) reap(maxLifetime time.Duration) {
+	for _, proc := range pm.OlderThan(maxLifetime) {
+		if proc.reaperExempt {
+			continue
+		}
+		switch err := pm.KillWithReason(proc.PID, KillReasonReaper); err {
+		case nil:
+			log.Warn("Process %s killed after running for longer than %v: %s", pm.FormatPID(proc.PID), maxLifetime, proc.CurrentDescription())
+		case ErrNotStarted:
+		default:
+			log.Error("Unable to reap process %s: %v", pm.FormatPID(proc.PID), err)
+		}
+	}
+}
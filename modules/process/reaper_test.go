@@ -0,0 +1,43 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_StartReaper(t *testing.T) {
+	pm := newFakeManager()
+
+	start := func(opts ...RunOption) *Handle {
+		h, err := pm.Start("Import", "hang", nil, opts...)
+		assert.NoError(t, err)
+		pm.mutex.Lock()
+		pm.Processes[h.PID()].Start = time.Now().Add(-time.Hour)
+		pm.mutex.Unlock()
+		return h
+	}
+	stuck := start()
+	exempt := start(WithExemptFromReaper())
+
+	pm.StartReaper(30*time.Minute, 10*time.Millisecond)
+	select {
+	case <-stuck.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reaper did not kill the process")
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-exempt.Done():
+		t.Fatal("the reaper killed an exempt process")
+	default:
+	}
+
+	assert.NoError(t, pm.Close())
+	<-exempt.Done()
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"time"
+)
+
+// The reasons recorded for the processes killed by the manager itself.
+const (
+	KillReasonTimeout        = "timeout"
+	KillReasonStartupTimeout = "startup timeout"
+	KillReasonShutdown       = "shutdown"
+	KillReasonReaper         = "maximum lifetime exceeded"
+	KillReasonStdinLimit     = "stdin limit exceeded"
+	// KillReasonOOM is a guess, see ExecError.OOMKilled.
+	KillReasonOOM = "probably the OOM killer"
+)
+
+// KillWithReason is Kill recording why the process is killed, e.g. "killed by admin
+// john", in its history entry and in the ExecError of the command.
+func (pm *Manager) KillWithReason(pid int64, reason string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return pm.kill(pid, reason)
+}
+
+// TerminateWithReason is Terminate recording reason as KillWithReason does.
+func (pm *Manager) TerminateWithReason(pid int64, grace time.Duration, reason string) error {
+	return pm.terminate(pid, grace, DefaultKillTimeout, reason)
+}
+
+// KillReason returns why the process was killed, if it was with a reason.
+func (p *Process) KillReason() string {
+	reason, _ := p.killReason.Load().(string)
+	return reason
+}
+
+func (p *Process) setKillReason(reason string) {
+	if reason != "" {
+		p.killReason.Store(reason)
+	}
+}
+
+// reason returns why the command was killed, once its state is known.
+func (h *Handle) reason(ctxErr error) string {
+	if h.state == StateExited {
+		// It exited by itself before it could be killed.
+		return ""
+	}
+	if reason := h.proc.KillReason(); reason != "" {
+		return reason
+	}
+	switch ctxErr {
+	case context.DeadlineExceeded:
+		return KillReasonTimeout
+	case ErrStartupTimeout:
+		return KillReasonStartupTimeout
+	}
+	if h.oomKilled(ctxErr) {
+		return KillReasonOOM
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// TerminationState tells how a command ended.
+type TerminationState int
+
+// The termination states of commands.
+const (
+	StateExited   TerminationState = iota // exited by itself, successfully or not
+	StateTimedOut                         // killed as its timeout or startup timeout expired
+	StateCanceled                         // killed as its context was canceled
+	StateKilled                           // killed by the manager or by a signal from outside
+)
+
+var terminationStateNames = map[TerminationState]string{
+	StateExited:   "exited",
+	StateTimedOut: "timed out",
+	StateCanceled: "canceled",
+	StateKilled:   "killed",
+}
+
+func (s TerminationState) String() string {
+	if name, ok := terminationStateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Result is the outcome of a command run by the manager.
+type Result struct {
+	Stdout, Stderr string
+	// ExitCode is the exit code of the command, or -1 if it did not exit normally (e.g. it was killed).
+	ExitCode int
+	Duration time.Duration
+	PID      int64
+	State    TerminationState
+	// Reason is why the command was killed, as in ExecError.
+	Reason string
+	// Attempts is the number of times the command was run, 1 unless run by ExecRetry.
+	Attempts int
+	// AttemptErrors are the errors of the attempts before the last one, by ExecRetry.
+	AttemptErrors []error
+	// ResolvedPath is the path of the executable that was run, as looked up in PATH once
+	// when the command was created, e.g. to tell which of several git binaries it was.
+	ResolvedPath string
+	// Dir is the working directory of the command, the one of Gitea if none was set.
+	Dir string
+}
+
+// ExecResult runs a command configured by opts like ExecWithOptions and returns its
+// result along with the error, if any (including timeout). The result is zero if the
+// command could not be started.
+func (pm *Manager) ExecResult(desc, cmdName string, args []string, opts ...RunOption) (Result, error) {
+	o := defaultRunOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return pm.execResult(desc, cmdName, args, o)
+}
+
+func (pm *Manager) execResult(desc, cmdName string, args []string, o runOptions) (Result, error) {
+	if o.dedupKey != "" {
+		return pm.flights.do(o.dedupKey, &o, func() (Result, error) {
+			o.dedupKey = ""
+			return pm.execResult(desc, cmdName, args, o)
+		})
+	}
+	h, err := pm.startWithOptions(desc, cmdName, args, o)
+	if err != nil {
+		return Result{}, err
+	}
+	h.wait()
+	return h.result(), h.err
+}
+
+// Result waits for the process to exit and returns its result, as well as Wait its error.
+func (h *Handle) Result() Result {
+	<-h.done
+	return h.result()
+}
+
+// result must be called once h.done is closed.
+func (h *Handle) result() Result {
+	return Result{
+		Stdout:   h.stdout,
+		Stderr:   h.stderr,
+		ExitCode: h.exitCode,
+		Duration: h.duration,
+		PID:      h.pid,
+		State:    h.state,
+		Reason:   h.killReason,
+		Attempts: 1,
+
+		ResolvedPath: h.cmd.Path,
+		Dir:          h.proc.dir,
+	}
+}
+
+// commandDir returns the absolute working directory of cmd.
+func commandDir(cmd *exec.Cmd) string {
+	if cmd.Dir == "" {
+		dir, _ := os.Getwd()
+		return dir
+	}
+	if dir, err := filepath.Abs(cmd.Dir); err == nil {
+		return dir
+	}
+	return cmd.Dir
+}
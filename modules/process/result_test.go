@@ -0,0 +1,80 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_ExecResult(t *testing.T) {
+	pm := newFakeManager()
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	r, err := pm.ExecResult("Echo", "echo", []string{"hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, Result{
+		Stdout:   "hello",
+		ExitCode: 0,
+		Duration: r.Duration,
+		PID:      1,
+		State:    StateExited,
+		Attempts: 1,
+
+		ResolvedPath: os.Args[0],
+		Dir:          wd,
+	}, r)
+	assert.True(t, r.Duration > 0)
+
+	r, err = pm.ExecResult("Fail", "fail", []string{"3", "boom"})
+	assert.Error(t, err)
+	assert.Equal(t, "boom", r.Stderr)
+	assert.Equal(t, 3, r.ExitCode)
+	assert.Equal(t, int64(2), r.PID)
+	assert.Equal(t, StateExited, r.State)
+
+	r, err = pm.ExecResult("Hang", "hang", nil, WithTimeout(100*time.Millisecond))
+	assert.True(t, errors.Is(err, ErrExecTimeout))
+	assert.Equal(t, -1, r.ExitCode)
+	assert.Equal(t, StateTimedOut, r.State)
+	assert.Equal(t, "timed out", r.State.String())
+	assert.True(t, r.Duration >= 100*time.Millisecond)
+
+	pm.Close()
+	r, err = pm.ExecResult("Closed", "echo", nil)
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, Result{}, r, "the command was not started")
+}
+
+func TestResult_ResolvedPath(t *testing.T) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "resolved-path")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+
+	pm := &Manager{Processes: make(map[int64]*Process)}
+	pm.SetHistorySize(1)
+	r, err := pm.ExecResult("Version", "git", []string{"--version"}, WithDir(dir))
+	assert.NoError(t, err)
+	assert.Equal(t, git, r.ResolvedPath)
+	assert.Equal(t, dir, r.Dir)
+	if history := pm.History(); assert.Len(t, history, 1) {
+		assert.Equal(t, git, history[0].Path)
+		assert.Equal(t, dir, history[0].Dir)
+	}
+}
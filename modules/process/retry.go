@@ -0,0 +1,97 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "regexp"
+
+// RetryPredicate reports whether a command which failed with err should be run again.
+type RetryPredicate func(err error) bool
+
+// RetryOnTimeout retries the commands killed because their timeout, or startup
+// timeout, expired.
+func RetryOnTimeout(err error) bool {
+	execErr, ok := err.(*ExecError)
+	return ok && execErr.Is(ErrExecTimeout)
+}
+
+// RetryOnExitCodes retries the commands which exited with one of codes.
+func RetryOnExitCodes(codes ...int) RetryPredicate {
+	return func(err error) bool {
+		execErr, ok := err.(*ExecError)
+		if !ok {
+			return false
+		}
+		for _, code := range codes {
+			if execErr.ExitCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RetryOnStderrMatch retries the commands whose stderr matches re, e.g. transient
+// network failures reported by git as "Could not resolve host".
+func RetryOnStderrMatch(re *regexp.Regexp) RetryPredicate {
+	return func(err error) bool {
+		execErr, ok := err.(*ExecError)
+		return ok && re.MatchString(execErr.Stderr)
+	}
+}
+
+// RetryAny retries the commands for which any of predicates does.
+func RetryAny(predicates ...RetryPredicate) RetryPredicate {
+	return func(err error) bool {
+		for _, retry := range predicates {
+			if retry(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ExecRetry runs a command like ExecResult, up to attempts times as long as it fails
+// with an error retry accepts. It returns the result and error of the last attempt, which
+// tell how many attempts were made and why the earlier ones failed. Errors which don't
+// come from the command itself, e.g. ErrClosed, are never retried. Commands reading
+// their stdin from a reader are only run once, as it is consumed. The buffers given with
+// WithStdoutBuffer and WithStderrBuffer only hold the output of the last attempt, while
+// the writers given with WithStdoutWriter get the output of every attempt.
+func (pm *Manager) ExecRetry(attempts int, retry RetryPredicate, desc, cmdName string, args []string, opts ...RunOption) (Result, error) {
+	o := defaultRunOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var stdoutLen, stderrLen int
+	if o.stdoutBuffer != nil {
+		stdoutLen = o.stdoutBuffer.Len()
+	}
+	if o.stderrBuffer != nil {
+		stderrLen = o.stderrBuffer.Len()
+	}
+	var attemptErrors []error
+	for attempt := 1; ; attempt++ {
+		o.attempt = attempt
+		r, err := pm.execResult(desc, cmdName, args, o)
+		r.Attempts, r.AttemptErrors = attempt, attemptErrors
+		execErr, ok := err.(*ExecError)
+		if !ok {
+			return r, err
+		}
+		execErr.Attempts, execErr.AttemptErrors = attempt, attemptErrors
+		if attempt >= attempts || o.stdin != nil || !retry(err) {
+			return r, err
+		}
+		attemptErrors = append(attemptErrors, err)
+		// The output of the caller buffers is in the error, which is kept.
+		if o.stdoutBuffer != nil {
+			o.stdoutBuffer.Truncate(stdoutLen)
+		}
+		if o.stderrBuffer != nil {
+			o.stderrBuffer.Truncate(stderrLen)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryOnTimeout(t *testing.T) {
+	assert.True(t, RetryOnTimeout(&ExecError{ExitCode: -1, ctxErr: context.DeadlineExceeded}))
+	assert.True(t, RetryOnTimeout(&ExecError{ExitCode: -1, ctxErr: ErrStartupTimeout}))
+	assert.False(t, RetryOnTimeout(&ExecError{ExitCode: -1, ctxErr: context.Canceled}))
+	assert.False(t, RetryOnTimeout(&ExecError{ExitCode: 128}))
+}
+
+func TestRetryOnExitCodes(t *testing.T) {
+	retry := RetryOnExitCodes(128, 255)
+	assert.True(t, retry(&ExecError{ExitCode: 128}))
+	assert.True(t, retry(&ExecError{ExitCode: 255}))
+	assert.False(t, retry(&ExecError{ExitCode: 1}))
+	assert.False(t, retry(ErrClosed))
+	assert.False(t, RetryOnExitCodes()(&ExecError{ExitCode: 128}))
+}
+
+func TestRetryOnStderrMatch(t *testing.T) {
+	retry := RetryOnStderrMatch(regexp.MustCompile(`Could not resolve host|Connection reset`))
+	assert.True(t, retry(&ExecError{ExitCode: 128, Stderr: "fatal: unable to access 'x': Could not resolve host: example.com"}))
+	assert.False(t, retry(&ExecError{ExitCode: 128, Stderr: "fatal: repository 'x' not found"}))
+	assert.False(t, retry(ErrExecTimeout))
+}
+
+func TestRetryAny(t *testing.T) {
+	retry := RetryAny(RetryOnTimeout, RetryOnExitCodes(128))
+	assert.True(t, retry(&ExecError{ExitCode: -1, ctxErr: context.DeadlineExceeded}))
+	assert.True(t, retry(&ExecError{ExitCode: 128}))
+	assert.False(t, retry(&ExecError{ExitCode: 1}))
+	assert.False(t, RetryAny()(&ExecError{ExitCode: 128}))
+}
+
+func TestManager_ExecRetry(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	dir, err := ioutil.TempDir("", "retry")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+
+	r, err := pm.ExecRetry(5, RetryOnExitCodes(3), "Flaky", "flaky", []string{runs, "3"})
+	assert.NoError(t, err)
+	assert.Equal(t, "run 3", r.Stdout)
+	assert.Equal(t, 3, r.Attempts, "it must succeed on the third attempt")
+	if assert.Len(t, r.AttemptErrors, 2) {
+		assert.Equal(t, "run 1 failed", r.AttemptErrors[0].(*ExecError).Stderr)
+		assert.Equal(t, "run 2 failed", r.AttemptErrors[1].(*ExecError).Stderr)
+	}
+	var attempts []int
+	for _, entry := range pm.History() {
+		attempts = append(attempts, entry.Attempt)
+	}
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+
+	_, err = pm.ExecRetry(2, RetryOnExitCodes(3), "Broken", "fail", []string{"3", "boom"})
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, "boom", execErr.Stderr)
+		assert.Equal(t, 2, execErr.Attempts, "attempts must be bounded")
+		assert.Len(t, execErr.AttemptErrors, 1)
+	}
+
+	r, err = pm.ExecRetry(3, RetryOnExitCodes(3), "Broken", "fail", []string{"1", "boom"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, r.Attempts, "other failures must not be retried")
+	assert.Len(t, pm.History(), 6)
+}
+
+func TestManager_ExecRetryOutputs(t *testing.T) {
+	pm := newFakeManager()
+
+	// A consumed stdin can't be fed again
+	r, err := pm.ExecRetry(3, RetryOnExitCodes(3), "Stdin", "fail", []string{"3", "boom"}, WithStdin(strings.NewReader("input")))
+	assert.Error(t, err)
+	assert.Equal(t, 1, r.Attempts)
+
+	dir, err := ioutil.TempDir("", "retry")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stdout, stderr := bytes.NewBufferString("kept "), new(bytes.Buffer)
+	r, err = pm.ExecRetry(3, RetryOnExitCodes(3), "Flaky", "flaky", []string{filepath.Join(dir, "runs"), "2"},
+		WithStdoutBuffer(stdout), WithStderrBuffer(stderr))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Attempts)
+	assert.Equal(t, "kept run 2", stdout.String(), "the buffers must only get the last attempt")
+	assert.Empty(t, stderr.String())
+	if assert.Len(t, r.AttemptErrors, 1) {
+		assert.Equal(t, "run 1 failed", r.AttemptErrors[0].(*ExecError).Stderr)
+	}
+}
@@ -0,0 +1,18 @@
+// +build dragonfly freebsd
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "math"
+
+// rlimitValue converts v to the type of the fields of syscall.Rlimit, RLIM_INFINITY
+// being the largest int64.
+func rlimitValue(v uint64) int64 {
+	if v > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(v)
+}
@@ -0,0 +1,12 @@
+// +build !windows,!dragonfly,!freebsd
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+// rlimitValue converts v to the type of the fields of syscall.Rlimit.
+func rlimitValue(v uint64) uint64 {
+	return v
+}
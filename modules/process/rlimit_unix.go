@@ -0,0 +1,113 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// rlimitsSupported tells whether startWithRlimits can limit the resources of commands.
+const rlimitsSupported = true
+
+// The environment variables through which startWithRlimits passes the command and its
+// limits to the executable of Gitea, see init.
+const (
+	rlimitShimPathEnv   = "GITEA_PROCESS_RLIMIT_PATH"
+	rlimitShimLimitsEnv = "GITEA_PROCESS_RLIMITS"
+)
+
+// init turns the executable into the shim of startWithRlimits when it is run by it: the
+// limits are set and the command is executed in its place, before anything else is run.
+func init() {
+	path, ok := os.LookupEnv(rlimitShimPathEnv)
+	if !ok {
+		return
+	}
+	if err := execWithRlimits(path, os.Getenv(rlimitShimLimitsEnv)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to run %s with resource limits: %v\n", path, err)
+		os.Exit(126)
+	}
+}
+
+// startWithRlimits starts cmd with limits set before it is executed. As they can't be set
+// between fork and exec, cmd is started through the executable of Gitea, which sets them
+// and executes the command in its place, with the same arguments and environment. The
+// Path and Env of cmd are restored once it has started.
+func startWithRlimits(cmd *exec.Cmd, limits []rlimit) error {
+	if len(limits) == 0 {
+		return cmd.Start()
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to limit the resources of the command: %v", err)
+	}
+
+	formatted := make([]string, 0, len(limits))
+	for _, limit := range limits {
+		formatted = append(formatted, fmt.Sprintf("%d:%d:%d", limit.resource, limit.soft, limit.hard))
+	}
+	path, env := cmd.Path, cmd.Env
+	shimEnv := env
+	if shimEnv == nil {
+		shimEnv = os.Environ()
+	}
+	cmd.Path = self
+	cmd.Env = append(shimEnv[:len(shimEnv):len(shimEnv)],
+		rlimitShimPathEnv+"="+path,
+		rlimitShimLimitsEnv+"="+strings.Join(formatted, ","))
+	err = cmd.Start()
+	cmd.Path, cmd.Env = path, env
+	return err
+}
+
+// execWithRlimits sets the limits formatted by startWithRlimits and executes path with
+// the arguments of the shim and its environment, without the variables of the shim.
+func execWithRlimits(path, limits string) error {
+	for _, s := range strings.Split(limits, ",") {
+		var limit rlimit
+		if _, err := fmt.Sscanf(s, "%d:%d:%d", &limit.resource, &limit.soft, &limit.hard); err != nil {
+			return fmt.Errorf("invalid limit %q: %v", s, err)
+		}
+		if err := setRlimit(limit); err != nil {
+			return err
+		}
+	}
+
+	environ := os.Environ()
+	env := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, rlimitShimPathEnv+"=") && !strings.HasPrefix(kv, rlimitShimLimitsEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return syscall.Exec(path, os.Args, env)
+}
+
+// setRlimit applies limit to the current process. The hard limit is capped to the current
+// one, inherited from Gitea, as only root may raise it.
+func setRlimit(limit rlimit) error {
+	resource := syscall.RLIMIT_CPU
+	if limit.resource == rlimitFileSize {
+		resource = syscall.RLIMIT_FSIZE
+	}
+	var l syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &l); err != nil {
+		return err
+	}
+	if hard := rlimitValue(limit.hard); hard < l.Max {
+		l.Max = hard
+	}
+	l.Cur = rlimitValue(limit.soft)
+	if l.Cur > l.Max {
+		l.Cur = l.Max
+	}
+	return syscall.Setrlimit(resource, &l)
+}
@@ -0,0 +1,78 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCPUTimeLimit(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	h, err := pm.Start("Limited", "sh", []string{"-c", "ulimit -t; ulimit -Ht; echo $" + rlimitShimPathEnv}, WithCPUTimeLimit(5))
+	assert.NoError(t, err)
+	sh, _ := exec.LookPath("sh")
+	assert.Equal(t, sh, h.cmd.Path, "the command must be run as is")
+	assert.Equal(t, []string{"sh", "-c", "ulimit -t; ulimit -Ht; echo $" + rlimitShimPathEnv}, h.cmd.Args)
+	assert.Nil(t, h.cmd.Env)
+	stdout, _, err := h.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "5\n6\n\n", stdout, "the variables of the shim are not passed on")
+
+	// A busy loop is killed by the kernel long before its timeout
+	start := time.Now()
+	_, _, err = pm.ExecWithOptions("Busy", "sh", []string{"-c", "while :; do :; done"}, WithCPUTimeLimit(1), WithTimeout(30*time.Second))
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.False(t, errors.Is(err, ErrExecTimeout))
+		assert.Equal(t, -1, execErr.ExitCode)
+	}
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestWithFileSizeLimit(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	dir, err := ioutil.TempDir("", "fsize")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Writing past the limit right away kills the command and leaves a file of the limit size
+	file := filepath.Join(dir, "big")
+	_, _, err = pm.ExecWithOptions("Big", "sh", []string{"-c", "head -c 100000 /dev/zero > " + file}, WithFileSizeLimit(4096))
+	assert.Error(t, err)
+	fi, err := os.Stat(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(4096), fi.Size())
+	}
+
+	_, _, err = pm.ExecWithOptions("Chrooted", "sh", []string{"-c", "true"}, WithFileSizeLimit(4096), WithChroot(dir))
+	assert.Error(t, err)
+}
+
+func TestWithFileSizeLimit_HardLimit(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	hard, _, err := pm.Exec("Unlimited", "sh", "-c", "ulimit -Hf")
+	assert.NoError(t, err)
+	if strings.TrimSpace(hard) == "unlimited" {
+		t.Skip("the hard limit of the file size is not set")
+	}
+
+	// A limit above the hard one of Gitea is capped instead of failing the command
+	stdout, _, err := pm.ExecWithOptions("Limited", "sh", []string{"-c", "ulimit -Hf"}, WithFileSizeLimit(^uint64(0)-1))
+	assert.NoError(t, err)
+	assert.Equal(t, hard, stdout)
+}
@@ -0,0 +1,16 @@
+// +build windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "os/exec"
+
+// rlimitsSupported tells whether startWithRlimits can limit the resources of commands.
+const rlimitsSupported = false
+
+func startWithRlimits(cmd *exec.Cmd, limits []rlimit) error {
+	return cmd.Start()
+}
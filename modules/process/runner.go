@@ -0,0 +1,33 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+// Runner runs commands with a preset working directory, environment and options,
+// e.g. for a subsystem issuing many git calls against the same repository.
+type Runner struct {
+	pm   *Manager
+	opts []RunOption
+}
+
+// NewRunner returns a Runner running its commands in dir with env and opts.
+func (pm *Manager) NewRunner(dir string, env []string, opts ...RunOption) *Runner {
+	preset := make([]RunOption, 0, len(opts)+2)
+	preset = append(preset, WithDir(dir), WithEnv(env))
+	return &Runner{pm: pm, opts: append(preset, opts...)}
+}
+
+// Run runs a command with the preset configuration and waits for its completion.
+// Returns its complete stdout and stderr outputs and an error, if any (including timeout).
+func (r *Runner) Run(desc, cmdName string, args ...string) (string, string, error) {
+	return r.pm.ExecWithOptions(desc, cmdName, args, r.opts...)
+}
+
+// RunWithOptions is Run with additional options, applied after the preset ones so
+// that they override them, e.g. WithDir for a command run in another directory.
+func (r *Runner) RunWithOptions(desc, cmdName string, args []string, opts ...RunOption) (string, string, error) {
+	all := make([]RunOption, 0, len(r.opts)+len(opts))
+	all = append(append(all, r.opts...), opts...)
+	return r.pm.ExecWithOptions(desc, cmdName, args, all...)
+}
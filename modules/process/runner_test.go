@@ -0,0 +1,56 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunner(t *testing.T) {
+	pm := newFakeManager()
+
+	dir, err := ioutil.TempDir("", "runner")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+	other, err := filepath.EvalSymlinks(os.TempDir())
+	assert.NoError(t, err)
+
+	r := pm.NewRunner(dir, []string{"RUNNER=preset"}, WithFailOnStderr())
+
+	stdout, _, err := r.Run("Pwd", "pwd")
+	assert.NoError(t, err)
+	assert.Equal(t, dir, stdout)
+
+	stdout, _, err = r.Run("Env", "env", "RUNNER", "HOME")
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNER=preset\n", stdout)
+
+	// The preset options apply to every command
+	_, _, err = r.Run("Warn", "fail", "0", "warning")
+	assert.Equal(t, ErrUnexpectedStderr, err.(*ExecError).Cause)
+
+	// Per-call options override the preset ones
+	stdout, _, err = r.RunWithOptions("Pwd", "pwd", nil, WithDir(other))
+	assert.NoError(t, err)
+	assert.Equal(t, other, stdout)
+	_, _, err = r.RunWithOptions("Hang", "hang", nil, WithTimeout(200*time.Millisecond))
+	assert.True(t, err.(*ExecError).Is(ErrExecTimeout))
+	stdout, _, err = r.RunWithOptions("Env", "env", []string{"RUNNER"}, WithEnv([]string{"RUNNER=call"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNER=call\n", stdout)
+
+	// and don't leak into later calls
+	stdout, _, err = r.Run("Pwd", "pwd")
+	assert.NoError(t, err)
+	assert.Equal(t, dir, stdout)
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// DefaultShutdownGrace is how long the manager returned by GetManager lets its processes
+// finish once the shutdown context is done, before killing them.
+const DefaultShutdownGrace = 30 * time.Second
+
+var (
+	// managerMutex guards manager along with the shutdown context it watches.
+	managerMutex sync.Mutex
+	shutdownCtx  context.Context
+	stopShutdown chan struct{} // closed to stop watching shutdownCtx
+)
+
+// SetShutdownContext ties the manager returned by GetManager to the shutdown of the server:
+// once ctx is done, it is drained, i.e. stops starting commands, which fail with ErrDraining,
+// and waits up to DefaultShutdownGrace for the running ones, then it is closed, killing
+// those still running. Like GetManager, it is meant to be called during initialization.
+// Calling it again replaces the context.
+func SetShutdownContext(ctx context.Context) {
+	managerMutex.Lock()
+	defer managerMutex.Unlock()
+	shutdownCtx = ctx
+	if manager != nil {
+		watchShutdownLocked()
+	}
+}
+
+// watchShutdownLocked makes manager watch shutdownCtx instead of the previous context, if
+// any. It must be called with managerMutex held.
+func watchShutdownLocked() {
+	if stopShutdown != nil {
+		close(stopShutdown)
+	}
+	stopShutdown = make(chan struct{})
+	manager.shutdownOn(shutdownCtx, DefaultShutdownGrace, stopShutdown)
+}
+
+// shutdownOn drains the manager once ctx is done and closes it after at most grace, unless
+// stop is closed first.
+func (pm *Manager) shutdownOn(ctx context.Context, grace time.Duration, stop <-chan struct{}) {
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			return
+		}
+		drainCtx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		if err := pm.Drain(drainCtx); err != nil {
+			log.Warn("Process manager %q: killing %d processes still running %v after shutdown", pm.Name, pm.Count(), grace)
+		}
+		if err := pm.Close(); err != nil {
+			log.Warn("Process manager %q: %v", pm.Name, err)
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_ShutdownOn(t *testing.T) {
+	pm := newFakeManager()
+	ctx, cancel := context.WithCancel(context.Background())
+	pm.shutdownOn(ctx, 3*time.Second, nil)
+
+	finishing, err := pm.Start("Finishing", "hang", []string{"300"})
+	assert.NoError(t, err)
+	stuck, err := pm.Start("Stuck", "hang", nil)
+	assert.NoError(t, err)
+
+	cancel()
+	eventually(t, func() bool {
+		pm.mutex.Lock()
+		defer pm.mutex.Unlock()
+		return pm.draining
+	}, time.Second, 10*time.Millisecond, "the manager must drain")
+	_, _, err = pm.Exec("Rejected", "echo")
+	assert.Equal(t, ErrDraining, err)
+
+	_, _, err = finishing.Wait()
+	assert.NoError(t, err, "running commands may finish during the grace period")
+	select {
+	case <-stuck.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the stuck command was not killed after the grace period")
+	}
+	_, _, err = pm.Exec("Closed", "echo")
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestSetShutdownContext(t *testing.T) {
+	defer func(pm *Manager, ctx context.Context) {
+		manager, shutdownCtx = pm, ctx
+	}(manager, shutdownCtx)
+	manager = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	SetShutdownContext(ctx)
+	pm := GetManager()
+	cancel()
+	eventually(t, func() bool {
+		pm.mutex.Lock()
+		defer pm.mutex.Unlock()
+		return pm.closed
+	}, time.Second, 10*time.Millisecond, "the singleton must be closed once idle")
+
+	// Setting the context again replaces the previous one, concurrently with GetManager
+	manager = nil
+	first, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	SetShutdownContext(first)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = GetManager()
+		}()
+	}
+	second, cancelSecond := context.WithCancel(context.Background())
+	SetShutdownContext(second)
+	wg.Wait()
+	pm = GetManager()
+	cancelFirst()
+	time.Sleep(100 * time.Millisecond)
+	pm.mutex.Lock()
+	assert.False(t, pm.closed, "the first context is not watched anymore")
+	pm.mutex.Unlock()
+	cancelSecond()
+	eventually(t, func() bool {
+		pm.mutex.Lock()
+		defer pm.mutex.Unlock()
+		return pm.closed
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,24 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os"
+	"syscall"
+)
+
+func pauseProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGSTOP)
+}
+
+func resumeProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGCONT)
+}
+
+func terminateProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,69 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// processState returns the state letter of a process from /proc, or "" if unavailable.
+func processState(pid int) string {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ""
+	}
+	// The state follows the parenthesised command name
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return fields[0]
+}
+
+func TestManager_PauseResume(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	h, err := pm.Start("PauseResume", "sleep", []string{"5"}, WithTimeout(2*time.Second))
+	assert.NoError(t, err)
+	osPID := h.cmd.Process.Pid
+
+	assert.NoError(t, pm.Pause(h.PID()))
+	assert.True(t, h.proc.Paused())
+	if state := processState(osPID); state != "" {
+		eventually(t, func() bool { return processState(osPID) == "T" }, time.Second, 10*time.Millisecond)
+	}
+
+	assert.NoError(t, pm.Resume(h.PID()))
+	assert.False(t, h.proc.Paused())
+	if state := processState(osPID); state != "" {
+		eventually(t, func() bool { return processState(osPID) == "S" }, time.Second, 10*time.Millisecond)
+	}
+
+	_, _, err = h.Wait()
+	assert.Error(t, err, "sleep should have been killed by its timeout")
+
+	assert.Equal(t, ErrNotFound, pm.Pause(h.PID()))
+	assert.Equal(t, ErrNotFound, pm.Resume(h.PID()))
+}
+
+func TestManager_Signal(t *testing.T) {
+	pm := newFakeManager()
+
+	h, err := pm.Start("Signal", "hang", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, pm.Signal(h.PID(), syscall.SIGTERM))
+	_, _, err = h.Wait()
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, syscall.SIGTERM, execErr.Signal)
+	}
+}
@@ -0,0 +1,22 @@
+// +build windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "os"
+
+func pauseProcess(p *os.Process) error {
+	return ErrUnsupported
+}
+
+func resumeProcess(p *os.Process) error {
+	return ErrUnsupported
+}
+
+// terminateProcess kills the process right away as there is no SIGTERM on Windows.
+func terminateProcess(p *os.Process) error {
+	return p.Kill()
+}
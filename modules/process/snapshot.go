@@ -0,0 +1,103 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"sort"
+	"time"
+)
+
+// ManagerSnapshot is a consistent view of a manager at a point in time.
+type ManagerSnapshot struct {
+	Time       time.Time
+	InstanceID string
+	Processes  []*Process // copies of the tracked processes, ordered by PID
+	Count      int        // always len(Processes)
+	Stats      Stats
+}
+
+// Snapshot returns the tracked processes, their count and the stats as of a single
+// lock acquisition, so that a dashboard doesn't show figures that disagree because
+// processes came and went between separate calls to Count, Stats and OlderThan.
+func (pm *Manager) Snapshot() ManagerSnapshot {
+	pm.mutex.Lock()
+	snap := ManagerSnapshot{
+		Time:       pm.timeNow(),
+		InstanceID: pm.InstanceID,
+		Processes:  make([]*Process, 0, len(pm.Processes)),
+		Stats:      pm.Stats(),
+	}
+	for _, proc := range pm.Processes {
+		snap.Processes = append(snap.Processes, proc.snapshot())
+	}
+	pm.mutex.Unlock()
+
+	snap.Count = len(snap.Processes)
+	sort.Slice(snap.Processes, func(i, j int) bool {
+		return snap.Processes[i].PID < snap.Processes[j].PID
+	})
+	return snap
+}
+
+// ProcessInfo describes a tracked process by value, without any pointer to the live
+// process, so that it can be handed to code which must not be able to affect it.
+type ProcessInfo struct {
+	PID         int64
+	OSPID       int // 0 if the command has not been started
+	Description string
+	RequestID   string
+	Category    Category
+	Key         string
+	Argv        []string // see Process.Argv
+	Start       time.Time
+	Elapsed     time.Duration
+	Paused      bool
+	StdoutBytes int64 // see Process.OutputBytes
+	StderrBytes int64
+	StdinBytes  int64      // see Process.StdinBytes
+	Env         []string   // see Process.Env
+	Privileges  Privileges // see SetAuditHook
+	Stack       string     // see SetDebugStacks
+}
+
+// ProcessesCopy returns a description of the tracked processes, ordered by PID.
+func (pm *Manager) ProcessesCopy() []ProcessInfo {
+	pm.mutex.Lock()
+	now := pm.timeNow()
+	infos := make([]ProcessInfo, 0, len(pm.Processes))
+	for _, proc := range pm.Processes {
+		infos = append(infos, proc.info(now))
+	}
+	pm.mutex.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].PID < infos[j].PID
+	})
+	return infos
+}
+
+// info must be called with the mutex held.
+func (p *Process) info(now time.Time) ProcessInfo {
+	info := ProcessInfo{
+		PID:         p.PID,
+		Description: p.CurrentDescription(),
+		RequestID:   p.RequestID,
+		Category:    p.Category,
+		Key:         p.Key,
+		Argv:        append([]string(nil), p.Argv...),
+		Start:       p.Start,
+		Elapsed:     now.Sub(p.Start),
+		Paused:      p.Paused(),
+		Env:         append([]string(nil), p.Env...),
+		Privileges:  p.privileges,
+		Stack:       p.stack,
+	}
+	info.StdoutBytes, info.StderrBytes = p.OutputBytes()
+	info.StdinBytes = p.StdinBytes()
+	if p.Cmd != nil && p.Cmd.Process != nil {
+		info.OSPID = p.Cmd.Process.Pid
+	}
+	return info
+}
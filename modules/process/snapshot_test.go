@@ -0,0 +1,109 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os/exec"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_Snapshot(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	// Processes come and go while snapshots are taken
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					pm.Remove(pm.Add("churn", exec.Command("foo")))
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		snap := pm.Snapshot()
+		assert.Equal(t, len(snap.Processes), snap.Count)
+		assert.True(t, sort.SliceIsSorted(snap.Processes, func(i, j int) bool {
+			return snap.Processes[i].PID < snap.Processes[j].PID
+		}))
+		for _, proc := range snap.Processes {
+			assert.Equal(t, "churn", proc.CurrentDescription())
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	pid := pm.Add("kept", exec.Command("foo"))
+	snap := pm.Snapshot()
+	if assert.Equal(t, 1, snap.Count) {
+		assert.Equal(t, pid, snap.Processes[0].PID)
+	}
+	snap.Processes[0].SetDescription("changed")
+	assert.Equal(t, "kept", pm.Processes[pid].CurrentDescription(), "the snapshot is a copy")
+}
+
+func TestManager_ProcessesCopy(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	second := pm.Add("second", exec.Command("foo"))
+	first := pm.Add("first", exec.Command("foo"))
+	pm.Processes[first].Start = pm.Processes[second].Start.Add(-time.Minute)
+
+	infos := pm.ProcessesCopy()
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, second, infos[0].PID)
+		assert.Equal(t, "second", infos[0].Description)
+		assert.Equal(t, 0, infos[0].OSPID, "not started")
+		assert.True(t, infos[1].Elapsed >= time.Minute)
+	}
+
+	infos[0].Description = "changed"
+	infos[0].PID = first
+	infos = append(infos[:1], ProcessInfo{PID: 42})
+	assert.Equal(t, "second", pm.Processes[second].CurrentDescription(), "the slice holds copies")
+	assert.Len(t, pm.Processes, 2)
+	assert.NotContains(t, pm.Processes, int64(42))
+}
+
+func TestManager_LiveOutputBytes(t *testing.T) {
+	pm := newFakeManager()
+
+	h, err := pm.Start("Lines", "lines", []string{"200", "1"})
+	assert.NoError(t, err)
+
+	// Run with -race: the counts are read while the outputs are being written.
+	var last int64
+	for done := false; !done; {
+		select {
+		case <-h.Done():
+			done = true
+		default:
+		}
+		stdout, _ := h.proc.OutputBytes()
+		assert.True(t, stdout >= last, "the count must never decrease")
+		last = stdout
+		for _, info := range pm.ProcessesCopy() {
+			assert.True(t, info.StdoutBytes <= int64(len("line 200\n")*200))
+		}
+		for _, proc := range pm.Snapshot().Processes {
+			proc.OutputBytes()
+		}
+	}
+	stdout, _, err := h.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(stdout)), last)
+}
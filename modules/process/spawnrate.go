@@ -0,0 +1,82 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// spawnRateSweepSize is the number of tracked descriptions above which the ones
+// not spawned during the last window are forgotten.
+const spawnRateSweepSize = 1024
+
+// variablePattern matches the parts of a description which vary between spawns
+// of the same command: numbers and commit IDs.
+var variablePattern = regexp.MustCompile(`\b[0-9a-f]{7,40}\b|[0-9]+`)
+
+// spawnRate limits how often commands with the same normalized description are spawned.
+type spawnRate struct {
+	mutex  sync.Mutex
+	max    int
+	window time.Duration
+	spawns map[string][]time.Time // spawn times within the window, oldest first
+}
+
+// SetSpawnRateLimit makes the manager refuse, with ErrSpawnRateExceeded, to start a
+// command whose description has already been spawned max times during the last window,
+// so that a caller spawning a failing command in a tight loop can't flood the host.
+// Descriptions are compared once numbers and commit IDs are normalized away.
+// 0 disables the limit, which is the default. It does not apply to processes added
+// with Add or Register.
+func (pm *Manager) SetSpawnRateLimit(max int, window time.Duration) {
+	sr := &pm.spawnRate
+	sr.mutex.Lock()
+	sr.max = max
+	sr.window = window
+	sr.spawns = nil
+	sr.mutex.Unlock()
+}
+
+// normalizeDescription returns the description with its variable parts normalized away.
+func normalizeDescription(desc string) string {
+	return variablePattern.ReplaceAllString(desc, "#")
+}
+
+// allow records a spawn of desc at now, unless it exceeds the limit.
+func (sr *spawnRate) allow(desc string, now time.Time) error {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	if sr.max <= 0 {
+		return nil
+	}
+	if sr.spawns == nil {
+		sr.spawns = make(map[string][]time.Time)
+	}
+
+	since := now.Add(-sr.window)
+	if len(sr.spawns) > spawnRateSweepSize {
+		for key, times := range sr.spawns {
+			if !times[len(times)-1].After(since) {
+				delete(sr.spawns, key)
+			}
+		}
+	}
+
+	key := normalizeDescription(desc)
+	times := sr.spawns[key]
+	recent := 0
+	for recent < len(times) && !times[recent].After(since) {
+		recent++
+	}
+	times = times[recent:]
+	if len(times) >= sr.max {
+		sr.spawns[key] = times
+		return ErrSpawnRateExceeded
+	}
+	sr.spawns[key] = append(times, now)
+	return nil
+}
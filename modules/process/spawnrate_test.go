@@ -0,0 +1,48 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_SetSpawnRateLimit(t *testing.T) {
+	pm := newFakeManager()
+	now := time.Now()
+	pm.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, _, err := pm.Exec("Echo", "echo")
+		assert.NoError(t, err, "there is no limit by default")
+	}
+
+	pm.SetSpawnRateLimit(2, time.Minute)
+	_, _, err := pm.Exec("Fail 1", "fail", "1", "boom")
+	assert.Error(t, err)
+	_, _, err = pm.Exec("Fail 2", "fail", "1", "boom")
+	assert.Error(t, err)
+	_, _, err = pm.Exec("Fail 3", "fail", "1", "boom")
+	assert.Equal(t, ErrSpawnRateExceeded, err, "numbers are normalized away")
+
+	_, _, err = pm.Exec("Echo", "echo")
+	assert.NoError(t, err, "other descriptions are not limited")
+
+	now = now.Add(time.Minute)
+	_, _, err = pm.Exec("Fail 4", "fail", "1", "boom")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrSpawnRateExceeded, err, "the window has passed")
+
+	pm.SetSpawnRateLimit(0, 0)
+	_, _, err = pm.Exec("Fail 5", "fail", "1", "boom")
+	assert.NotEqual(t, ErrSpawnRateExceeded, err)
+}
+
+func TestNormalizeDescription(t *testing.T) {
+	assert.Equal(t, "GetCommit(#): #", normalizeDescription("GetCommit(42): 0123abcd"))
+	assert.Equal(t, "git gc [/data/repo.git]", normalizeDescription("git gc [/data/repo.git]"))
+}
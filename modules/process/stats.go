@@ -0,0 +1,38 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "sync/atomic"
+
+// Stats holds the cumulative counters of a Manager since it was created.
+type Stats struct {
+	// TimedOut is the number of processes killed because their timeout expired.
+	TimedOut int64
+	// Killed is the number of processes killed through Kill, KillAll or Close.
+	Killed int64
+	// Canceled is the number of processes killed because their parent context was canceled.
+	Canceled int64
+	// OutputCapped is the number of processes whose output was truncated
+	// because the manager output budget was exhausted.
+	OutputCapped int64
+}
+
+// counters are the atomically updated counters behind Stats.
+type counters struct {
+	timedOut     int64
+	killed       int64
+	canceled     int64
+	outputCapped int64
+}
+
+// Stats returns a copy of the current counters.
+func (pm *Manager) Stats() Stats {
+	return Stats{
+		TimedOut:     atomic.LoadInt64(&pm.stats.timedOut),
+		Killed:       atomic.LoadInt64(&pm.stats.killed),
+		Canceled:     atomic.LoadInt64(&pm.stats.canceled),
+		OutputCapped: atomic.LoadInt64(&pm.stats.outputCapped),
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_Stats(t *testing.T) {
+	pm := newFakeManager()
+	assert.Equal(t, Stats{}, pm.Stats())
+
+	_, _, err := pm.ExecTimeout(100*time.Millisecond, "Timeout", "hang")
+	assert.Error(t, err)
+	assert.Equal(t, Stats{TimedOut: 1}, pm.Stats())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, _, err = pm.ExecWithOptions("Canceled", "hang", nil, WithContext(ctx))
+	assert.Error(t, err)
+	assert.Equal(t, Stats{TimedOut: 1, Canceled: 1}, pm.Stats())
+
+	h, err := pm.Start("Killed", "hang", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, pm.Kill(h.PID()))
+	_, _, err = h.Wait()
+	assert.Error(t, err)
+	assert.Equal(t, Stats{TimedOut: 1, Canceled: 1, Killed: 1}, pm.Stats())
+
+	// Failures and successes are not terminations
+	_, _, err = pm.Exec("Fail", "fail", "1", "boom")
+	assert.Error(t, err)
+	_, _, err = pm.Exec("Echo", "echo", "hi")
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{TimedOut: 1, Canceled: 1, Killed: 1}, pm.Stats())
+}
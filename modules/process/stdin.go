@@ -0,0 +1,113 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+)
+
+// bufferedStdinMemoryLimit is how much of the stdin buffered by WithBufferedStdin is kept
+// in memory, the rest being spilled to a temporary file.
+const bufferedStdinMemoryLimit = 1 << 20
+
+// WithBufferedStdin reads the reader given with WithStdin entirely before the command is
+// started, in memory up to 1MiB and in a temporary file beyond, and then feeds the copy.
+// The manager copies stdin in its own goroutine while it reads the outputs, so a command
+// filling its stdout before it has consumed its stdin, like cat, can't deadlock either way.
+// Prefer this option when the reader must be done with before the command runs or waits
+// for a free slot, e.g. a request body or a reader holding a lock, and the streaming path
+// otherwise, as it doesn't hold the whole input. A read error fails the command unstarted.
+func WithBufferedStdin() RunOption {
+	return func(o *runOptions) {
+		o.bufferStdin = true
+	}
+}
+
+// bufferStdin reads r entirely, into memory up to limit bytes and into a temporary file
+// beyond. The returned function removes the temporary file, if any.
+func bufferStdin(r io.Reader, limit int64) (io.Reader, func(), error) {
+	buf := new(bytes.Buffer)
+	if n, err := io.CopyN(buf, r, limit+1); err == io.EOF || (err == nil && n <= limit) {
+		return bytes.NewReader(buf.Bytes()), func() {}, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := ioutil.TempFile("", "gitea-stdin")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	if _, err := buf.WriteTo(f); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
+
+// WithStdinLimit kills the command once more than limit bytes are read from the reader
+// given with WithStdin, e.g. to cap the size of a push, and makes it fail with
+// ErrStdinLimitExceeded. The command only gets the first limit bytes. A limit of 0 or
+// less means no limit.
+func WithStdinLimit(limit int64) RunOption {
+	return func(o *runOptions) {
+		o.stdinLimit = limit
+	}
+}
+
+// copyStdin copies r to the stdin pipe of the command, counting the bytes written, and
+// kills the command if r holds more than limit bytes. The error of the copy is kept in
+// h.stdinErr for wait, except for the writes failing because the command has exited
+// without reading all of its stdin.
+func (h *Handle) copyStdin(pipe io.WriteCloser, r io.Reader, limit int64) {
+	defer close(h.stdinDone)
+	w := &countingWriter{w: pipe, count: &h.proc.stdinBytes}
+	if limit <= 0 {
+		_, err := io.Copy(w, r)
+		h.setStdinErr(err)
+		_ = pipe.Close()
+		return
+	}
+
+	n, err := io.Copy(w, io.LimitReader(r, limit))
+	h.setStdinErr(err)
+	if err == nil && n == limit {
+		var b [1]byte
+		m, err := io.ReadFull(r, b[:])
+		if m > 0 {
+			atomic.StoreInt32(&h.stdinExceeded, 1)
+			_ = h.pm.KillWithReason(h.pid, KillReasonStdinLimit)
+		} else if err != io.EOF {
+			h.setStdinErr(err)
+		}
+	}
+	_ = pipe.Close()
+}
+
+// setStdinErr records err as the error of the copy to stdin unless it is nil or comes
+// from writing to the pipe of a command which has exited or closed its stdin.
+func (h *Handle) setStdinErr(err error) {
+	if pe, ok := err.(*os.PathError); ok && (pe.Err == os.ErrClosed || isBrokenPipe(pe.Err)) {
+		return
+	}
+	if err != nil {
+		h.stdinErr = err
+	}
+}
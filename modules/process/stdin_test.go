@@ -0,0 +1,117 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithBufferedStdin(t *testing.T) {
+	pm := newFakeManager()
+	pm.SetHistorySize(10)
+
+	// cat writes its output as it reads its input: with both larger than the pipe
+	// buffers, writing stdin blocks unless stdout is read at the same time.
+	input := strings.Repeat("0123456789abcdef", 256*1024)
+	for _, opts := range [][]RunOption{
+		{WithStdin(strings.NewReader(input))},
+		{WithStdin(strings.NewReader(input)), WithBufferedStdin()},
+	} {
+		stdout, stderr, err := pm.ExecWithOptions("Cat", "cat", nil, opts...)
+		assert.NoError(t, err, stderr)
+		assert.True(t, stdout == input, "the output must be the input")
+	}
+
+	_, _, err := pm.ExecWithOptions("Unreadable", "cat", nil, WithStdin(&failingReader{}), WithBufferedStdin())
+	assert.EqualError(t, err, "failed to buffer stdin: unreadable")
+	assert.Len(t, pm.History(), 2, "the command must not be started")
+}
+
+func TestBufferStdin(t *testing.T) {
+	input := strings.Repeat("x", 100)
+
+	r, cleanup, err := bufferStdin(strings.NewReader(input), 100)
+	assert.NoError(t, err)
+	assert.IsType(t, &bytes.Reader{}, r, "the input fits in memory")
+	data, _ := ioutil.ReadAll(r)
+	assert.Equal(t, input, string(data))
+	cleanup()
+
+	r, cleanup, err = bufferStdin(strings.NewReader(input), 10)
+	assert.NoError(t, err)
+	if f, ok := r.(*os.File); assert.True(t, ok, "the input must be spilled to a file") {
+		data, _ = ioutil.ReadAll(f)
+		assert.Equal(t, input, string(data))
+		cleanup()
+		_, err = os.Stat(f.Name())
+		assert.True(t, os.IsNotExist(err), "the file must be removed")
+	}
+}
+
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("unreadable")
+}
+
+func TestWithStdinLimit(t *testing.T) {
+	pm := newFakeManager()
+
+	var proc *Process
+	r, err := pm.ExecResult("Push", "cat", nil, WithStdin(strings.NewReader(strings.Repeat("x", 1<<20))), WithStdinLimit(1000),
+		WithAfterStart(func(p *Process) {
+			proc = p
+		}))
+	assert.True(t, errors.Is(err, ErrStdinLimitExceeded))
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, KillReasonStdinLimit, execErr.Reason)
+	}
+	assert.Equal(t, StateKilled, r.State)
+	assert.True(t, len(r.Stdout) <= 1000, "the command must only get the first bytes")
+	assert.Equal(t, int64(1000), proc.StdinBytes())
+
+	// Exactly the limit is fine
+	stdout, _, err := pm.ExecWithOptions("Push", "cat", nil, WithStdin(strings.NewReader(strings.Repeat("x", 1000))), WithStdinLimit(1000),
+		WithAfterStart(func(p *Process) {
+			proc = p
+		}))
+	assert.NoError(t, err)
+	assert.Len(t, stdout, 1000)
+	assert.Equal(t, int64(1000), proc.StdinBytes())
+}
+
+type truncatedReader struct {
+	data string
+	err  error
+}
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWithStdin_ReadError(t *testing.T) {
+	pm := newFakeManager()
+	readErr := errors.New("connection reset")
+
+	_, _, err := pm.ExecWithOptions("ReadError", "cat", nil, WithStdin(&truncatedReader{data: "partial", err: readErr}))
+	assert.True(t, errors.Is(err, readErr), "the read error must fail the command, got %v", err)
+
+	// A command exiting without reading its stdin is not an error
+	_, _, err = pm.ExecWithOptions("Unread", "echo", []string{"done"}, WithStdin(strings.NewReader(strings.Repeat("x", 1<<20))))
+	assert.NoError(t, err)
+}
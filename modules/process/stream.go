@@ -0,0 +1,34 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"context"
+	"io"
+)
+
+// ExecStream runs a command and writes its stdout to w as it is produced, calling
+// flush after every write, e.g. to push it to a chunked or server-sent events response.
+// Canceling ctx kills the command; what was already written to w stays written.
+// flush may be nil.
+func (pm *Manager) ExecStream(ctx context.Context, w io.Writer, flush func(), desc, cmdName string, args []string, opts ...RunOption) error {
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx), WithStdoutWriter(&flushWriter{w: w, flush: flush}))
+	_, _, err := pm.ExecWithOptions(desc, cmdName, args, opts...)
+	return err
+}
+
+// flushWriter calls flush after every write.
+type flushWriter struct {
+	w     io.Writer
+	flush func()
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.flush != nil {
+		fw.flush()
+	}
+	return n, err
+}
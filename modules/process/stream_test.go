@@ -0,0 +1,70 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_ExecStream(t *testing.T) {
+	pm := newFakeManager()
+
+	r, w := io.Pipe()
+	var flushes int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		err := pm.ExecStream(ctx, w, func() { atomic.AddInt32(&flushes, 1) }, "Stream", "lines", []string{"100", "50"})
+		w.Close()
+		done <- err
+	}()
+
+	scanner := bufio.NewScanner(r)
+	var lines []string
+	for len(lines) < 3 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{"line 1", "line 2", "line 3"}, lines)
+	cancel()
+
+	// Drain what was written before the kill
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	select {
+	case err := <-done:
+		assert.Error(t, err, "the command should have been killed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not killed when the context was canceled")
+	}
+	assert.True(t, len(lines) < 100)
+	assert.True(t, atomic.LoadInt32(&flushes) >= 3)
+	assert.Equal(t, 0, pm.Count())
+
+	// Without cancellation, all of the output arrives
+	r, w = io.Pipe()
+	go func() {
+		err := pm.ExecStream(context.Background(), w, nil, "Stream", "lines", []string{"5", "0"})
+		w.Close()
+		done <- err
+	}()
+	lines = lines[:0]
+	scanner = bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.NoError(t, <-done)
+	assert.Len(t, lines, 5)
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// DefaultKillTimeout is how long Terminate waits for a process to die once killed.
+const DefaultKillTimeout = 5 * time.Second
+
+// DefaultTerminateGrace is how long bulk terminations let processes exit after SIGTERM.
+const DefaultTerminateGrace = 10 * time.Second
+
+// terminatePollInterval is how often Terminate checks whether the process has exited.
+const terminatePollInterval = 10 * time.Millisecond
+
+// Terminate asks a process to exit with SIGTERM and kills it with SIGKILL if it is still
+// running after grace, then waits up to DefaultKillTimeout for it to die. If it doesn't,
+// e.g. as it is stuck in an uninterruptible sleep, the OS PID is logged, the process
+// stays tracked and ErrKillTimeout is returned, so that the caller isn't blocked forever.
+// A process is considered dead once it has been waited for: the commands run by the
+// manager are, while the caller must be waiting for the processes added with Add.
+// On Windows the process is killed right away.
+func (pm *Manager) Terminate(pid int64, grace time.Duration) error {
+	return pm.terminate(pid, grace, DefaultKillTimeout, "")
+}
+
+func (pm *Manager) terminate(pid int64, grace, killTimeout time.Duration, reason string) error {
+	pm.mutex.Lock()
+	proc, p, err := pm.startedLocked(pid)
+	pm.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	proc.setKillReason(reason)
+	atomic.StoreInt32(&proc.killed, 1)
+	if err := terminateProcess(p); err != nil {
+		if err.Error() == errProcessDone {
+			pm.Remove(pid)
+			return nil
+		}
+		return fmt.Errorf("failed to terminate process(%s/%s): %v", pm.FormatPID(pid), proc.CurrentDescription(), err)
+	}
+	if waitForExit(p, grace) {
+		pm.Remove(pid)
+		return nil
+	}
+
+	if err := p.Kill(); err == nil {
+		atomic.AddInt64(&pm.stats.killed, 1)
+	} else if err.Error() != errProcessDone {
+		return fmt.Errorf("failed to kill process(%s/%s): %v", pm.FormatPID(pid), proc.CurrentDescription(), err)
+	}
+	if !waitForExit(p, killTimeout) {
+		log.Warn("Process %s (%s) with OS PID %d is still alive %v after being killed", pm.FormatPID(pid), proc.CurrentDescription(), p.Pid, killTimeout)
+		return ErrKillTimeout
+	}
+	pm.Remove(pid)
+	return nil
+}
+
+// waitForExit reports whether the process exits, i.e. is waited for, within timeout.
+func waitForExit(p *os.Process, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := p.Signal(syscall.Signal(0)); err != nil && err.Error() == errProcessDone {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(terminatePollInterval)
+	}
+}
@@ -0,0 +1,79 @@
+// +build !windows
+
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_Terminate(t *testing.T) {
+	pm := newFakeManager()
+	assert.Equal(t, ErrNotFound, pm.Terminate(42, time.Second))
+
+	h, err := pm.Start("Hang", "hang", nil)
+	assert.NoError(t, err)
+	start := time.Now()
+	assert.NoError(t, pm.Terminate(h.PID(), 10*time.Second))
+	assert.True(t, time.Since(start) < 5*time.Second, "SIGTERM was not enough")
+	_, _, err = h.Wait()
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, syscall.SIGTERM, execErr.Signal)
+		assert.False(t, execErr.OOMKilled)
+	}
+	assert.Equal(t, 0, pm.Count())
+}
+
+func TestManager_Terminate_Escalation(t *testing.T) {
+	pm := newFakeManager()
+
+	r, w := io.Pipe()
+	h, err := pm.Start("IgnoreTerm", "ignoreterm", nil, WithStdoutWriter(w))
+	assert.NoError(t, err)
+	// SIGTERM must not be sent before the helper ignores it
+	_, err = bufio.NewReader(r).ReadString('\n')
+	assert.NoError(t, err)
+	go io.Copy(ioutil.Discard, r)
+
+	start := time.Now()
+	assert.NoError(t, pm.Terminate(h.PID(), 100*time.Millisecond))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "the grace period was skipped")
+	_, _, err = h.Wait()
+	w.Close()
+	var execErr *ExecError
+	if assert.True(t, errors.As(err, &execErr)) {
+		assert.Equal(t, syscall.SIGKILL, execErr.Signal)
+		assert.False(t, execErr.OOMKilled)
+	}
+	assert.Equal(t, 0, pm.Count())
+}
+
+func TestManager_Terminate_KillTimeout(t *testing.T) {
+	pm := NewManager()
+
+	// A process which is never waited for stays a zombie once killed
+	cmd := exec.Command("sleep", "5")
+	assert.NoError(t, cmd.Start())
+	pid := pm.Add("Zombie", cmd)
+
+	start := time.Now()
+	assert.Equal(t, ErrKillTimeout, pm.terminate(pid, 50*time.Millisecond, 200*time.Millisecond, ""))
+	assert.True(t, time.Since(start) < 2*time.Second, "Terminate was not bounded")
+	assert.Equal(t, 1, pm.Count(), "a process which refuses to die stays tracked")
+
+	assert.Error(t, cmd.Wait())
+	pm.Remove(pid)
+}
@@ -0,0 +1,19 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package copymanager copies a process manager by value, which go vet must report.
+package copymanager
+
+import "code.gitea.io/gitea/modules/process"
+
+// Count must take the manager by pointer.
+func Count(pm process.Manager) int {
+	return pm.Count()
+}
+
+// Copy dereferences a manager.
+func Copy() int {
+	pm := *process.NewManager()
+	return Count(pm)
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Tracer starts a span around every command executed by a Manager.
+// It is deliberately minimal so that a tracing library (e.g. OpenTelemetry)
+// can be plugged in by the caller without this package depending on it.
+type Tracer interface {
+	StartSpan(desc string, attrs SpanAttributes) Span
+}
+
+// SpanAttributes describes the command a span is started for.
+type SpanAttributes struct {
+	Argc int
+	Dir  string
+}
+
+// Span is a single traced command execution.
+type Span interface {
+	// Environ returns environment variables ("KEY=VALUE") that propagate
+	// the trace context into the child, e.g. TRACEPARENT.
+	Environ() []string
+	// End records the outcome of the command. err is nil on success.
+	End(exitCode int, duration time.Duration, err error)
+}
+
+// SetTracer sets the tracer used for all subsequent executions. A nil tracer disables tracing.
+func (pm *Manager) SetTracer(tracer Tracer) {
+	pm.mutex.Lock()
+	pm.tracer = tracer
+	pm.mutex.Unlock()
+}
+
+// startSpan starts a span for cmd if a tracer is set and adds the propagation
+// environment to it. It returns nil if tracing is disabled.
+func (pm *Manager) startSpan(desc string, cmd *exec.Cmd) Span {
+	pm.mutex.Lock()
+	tracer := pm.tracer
+	pm.mutex.Unlock()
+	if tracer == nil {
+		return nil
+	}
+
+	span := tracer.StartSpan(desc, SpanAttributes{
+		Argc: len(cmd.Args),
+		Dir:  cmd.Dir,
+	})
+	if extra := span.Environ(); len(extra) > 0 {
+		env := cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		cmd.Env = append(append(make([]string, 0, len(env)+len(extra)), env...), extra...)
+	}
+	return span
+}
+
+// endSpan records the outcome of cmd on span, if any.
+func endSpan(span Span, cmd *exec.Cmd, start time.Time, err error) {
+	if span == nil {
+		return
+	}
+	span.End(exitCode(cmd.ProcessState), time.Since(start), err)
+}
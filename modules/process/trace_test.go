@@ -0,0 +1,74 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSpan struct {
+	desc     string
+	attrs    SpanAttributes
+	exitCode int
+	err      error
+	ended    bool
+}
+
+func (s *testSpan) Environ() []string {
+	return []string{"TRACEPARENT=00-trace-span-01"}
+}
+
+func (s *testSpan) End(exitCode int, duration time.Duration, err error) {
+	s.exitCode = exitCode
+	s.err = err
+	s.ended = true
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(desc string, attrs SpanAttributes) Span {
+	span := &testSpan{desc: desc, attrs: attrs}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func TestManager_Tracer(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+	tracer := &testTracer{}
+	pm.SetTracer(tracer)
+
+	stdout, _, err := pm.ExecDirEnv(-1, "/", "TraceOK", []string{"FOO=bar"}, "env")
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Split(stdout, "\n"), "TRACEPARENT=00-trace-span-01")
+	assert.Contains(t, strings.Split(stdout, "\n"), "FOO=bar")
+
+	_, _, err = pm.Exec("TraceFail", "git", "no-such-subcommand")
+	assert.Error(t, err)
+
+	if assert.Len(t, tracer.spans, 2) {
+		ok, failed := tracer.spans[0], tracer.spans[1]
+		assert.Equal(t, "TraceOK", ok.desc)
+		assert.Equal(t, SpanAttributes{Argc: 1, Dir: "/"}, ok.attrs)
+		assert.True(t, ok.ended)
+		assert.Equal(t, 0, ok.exitCode)
+		assert.NoError(t, ok.err)
+
+		assert.Equal(t, "TraceFail", failed.desc)
+		assert.True(t, failed.ended)
+		assert.NotEqual(t, 0, failed.exitCode)
+		assert.Error(t, failed.err)
+	}
+
+	pm.SetTracer(nil)
+	_, _, err = pm.Exec("NoTrace", "git", "--version")
+	assert.NoError(t, err)
+	assert.Len(t, tracer.spans, 2)
+}
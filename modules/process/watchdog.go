@@ -0,0 +1,96 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// watchdog periodically logs the processes running for longer than a threshold.
+type watchdog struct {
+	threshold time.Duration
+	interval  time.Duration
+	logf      func(format string, v ...interface{})
+
+	warned map[int64]time.Time // when each process was last logged
+	stop   chan struct{}
+}
+
+// StartWatchdog starts a goroutine that logs, every interval, the processes still
+// running after threshold, so that stuck operations show up in the logs. Each process
+// is logged at most once per interval, except those run WithExemptFromWatchdog.
+// logf defaults to log.Warn if nil. Any previously started watchdog is stopped.
+func (pm *Manager) StartWatchdog(threshold, interval time.Duration, logf func(format string, v ...interface{})) {
+	if logf == nil {
+		logf = log.Warn
+	}
+	wd := &watchdog{
+		threshold: threshold,
+		interval:  interval,
+		logf:      logf,
+		warned:    make(map[int64]time.Time),
+		stop:      make(chan struct{}),
+	}
+
+	pm.mutex.Lock()
+	if pm.watchdog != nil {
+		close(pm.watchdog.stop)
+	}
+	pm.watchdog = wd
+	pm.mutex.Unlock()
+
+	go wd.run(pm)
+}
+
+// StopWatchdog stops the watchdog started by StartWatchdog, if any.
+func (pm *Manager) StopWatchdog() {
+	pm.mutex.Lock()
+	if pm.watchdog != nil {
+		close(pm.watchdog.stop)
+		pm.watchdog = nil
+	}
+	pm.mutex.Unlock()
+}
+
+func (wd *watchdog) run(pm *Manager) {
+	ticker := time.NewTicker(wd.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wd.check(pm)
+		case <-wd.stop:
+			return
+		}
+	}
+}
+
+// check logs the long running processes that haven't been logged during the last interval.
+func (wd *watchdog) check(pm *Manager) {
+	now := pm.timeNow()
+	running := make(map[int64]bool)
+	for _, proc := range pm.OlderThan(wd.threshold) {
+		if proc.watchdogExempt {
+			continue
+		}
+		running[proc.PID] = true
+		if last, ok := wd.warned[proc.PID]; ok && now.Sub(last) < wd.interval {
+			continue
+		}
+		wd.warned[proc.PID] = now
+		desc := proc.CurrentDescription()
+		if proc.RequestID != "" {
+			desc += " (request " + proc.RequestID + ")"
+		}
+		wd.logf("Process %s still running after %v: %s", pm.FormatPID(proc.PID), now.Sub(proc.Start).Round(time.Second), desc)
+	}
+	for pid := range wd.warned {
+		if !running[pid] {
+			delete(wd.warned, pid)
+		}
+	}
+}
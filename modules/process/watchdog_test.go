@@ -0,0 +1,92 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdog(t *testing.T) {
+	now := time.Date(2019, 11, 25, 12, 0, 0, 0, time.UTC)
+	pm := Manager{Processes: make(map[int64]*Process), now: func() time.Time { return now }}
+
+	var logged []string
+	wd := &watchdog{
+		threshold: 2 * time.Minute,
+		interval:  time.Minute,
+		logf: func(format string, v ...interface{}) {
+			logged = append(logged, fmt.Sprintf(format, v...))
+		},
+		warned: make(map[int64]time.Time),
+	}
+
+	pid := pm.Add("git clone", nil)
+	now = now.Add(time.Minute)
+	wd.check(&pm)
+	assert.Empty(t, logged, "under the threshold")
+
+	now = now.Add(time.Minute + time.Second)
+	wd.check(&pm)
+	assert.Equal(t, []string{"Process 1 still running after 2m1s: git clone"}, logged)
+
+	now = now.Add(30 * time.Second)
+	wd.check(&pm)
+	assert.Len(t, logged, 1, "logged at most once per interval")
+
+	now = now.Add(30 * time.Second)
+	wd.check(&pm)
+	assert.Len(t, logged, 2)
+	assert.Equal(t, "Process 1 still running after 3m1s: git clone", logged[1])
+
+	pm.Remove(pid)
+	now = now.Add(time.Minute)
+	wd.check(&pm)
+	assert.Len(t, logged, 2)
+	assert.Empty(t, wd.warned, "finished processes are forgotten")
+}
+
+func TestManager_StartWatchdog(t *testing.T) {
+	pm := NewManager()
+	pm.Add("stuck", nil)
+
+	logged := make(chan string, 10)
+	pm.StartWatchdog(0, 10*time.Millisecond, func(format string, v ...interface{}) {
+		logged <- fmt.Sprintf(format, v...)
+	})
+	select {
+	case msg := <-logged:
+		assert.Contains(t, msg, "stuck")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watchdog did not log the process")
+	}
+	assert.NoError(t, pm.Close())
+}
+
+func TestWatchdog_Exempt(t *testing.T) {
+	pm := Manager{Processes: make(map[int64]*Process)}
+
+	var logged []string
+	wd := &watchdog{
+		threshold: time.Minute,
+		interval:  time.Minute,
+		logf: func(format string, v ...interface{}) {
+			logged = append(logged, fmt.Sprintf(format, v...))
+		},
+		warned: make(map[int64]time.Time),
+	}
+	for _, desc := range []string{"stuck", "initial import"} {
+		pid := pm.Add(desc, nil)
+		pm.Processes[pid].Start = time.Now().Add(-time.Hour)
+		pm.Processes[pid].watchdogExempt = desc == "initial import"
+	}
+	wd.check(&pm)
+	if assert.Len(t, logged, 1) {
+		assert.Contains(t, logged[0], "stuck")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import "sync"
+
+// QueuePolicy tells what Submit does when the queue of the worker pool is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes Submit wait for room in the queue.
+	QueueBlock QueuePolicy = iota
+	// QueueFail makes Submit fail with ErrQueueFull.
+	QueueFail
+)
+
+// Job is a command submitted to the worker pool of a manager.
+type Job struct {
+	Spec ExecSpec
+
+	stdout, stderr string
+	err            error
+	done           chan struct{}
+}
+
+// Done returns a channel closed once the job has run.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait waits for the job to have run and returns its outputs and error.
+func (j *Job) Wait() (string, string, error) {
+	<-j.done
+	return j.stdout, j.stderr, j.err
+}
+
+func (j *Job) finish(stdout, stderr string, err error) {
+	j.stdout, j.stderr, j.err = stdout, stderr, err
+	close(j.done)
+}
+
+// workerPool runs the submitted jobs on a fixed number of goroutines.
+type workerPool struct {
+	jobs   chan *Job
+	policy QueuePolicy
+
+	// mutex is held for reading while submitting, so that stopping can wait for
+	// the jobs being submitted before failing the ones left in the queue.
+	mutex   sync.RWMutex
+	stopped bool
+	stop    chan struct{}
+}
+
+// WithWorkerPool makes the manager run the jobs given to Submit on n worker goroutines,
+// which pull them from a queue of queueSize jobs: the number of goroutines stays bounded
+// however many jobs are queued. policy tells what Submit does when the queue is full.
+// Any previous pool is stopped and the jobs still in its queue fail with ErrClosed, as
+// they do when the manager is closed.
+func (pm *Manager) WithWorkerPool(n, queueSize int, policy QueuePolicy) {
+	p := &workerPool{
+		jobs:   make(chan *Job, queueSize),
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+
+	pm.mutex.Lock()
+	previous := pm.workers
+	pm.workers = p
+	pm.mutex.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+
+	for i := 0; i < n; i++ {
+		go p.work(pm)
+	}
+}
+
+// Submit queues a command to be run by the worker pool set up with WithWorkerPool.
+// It returns ErrNoWorkerPool if there is none, and ErrQueueFull if the queue is full
+// and the policy of the pool is QueueFail.
+func (pm *Manager) Submit(spec ExecSpec) (*Job, error) {
+	pm.mutex.Lock()
+	p := pm.workers
+	closed := pm.closed
+	pm.mutex.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if p == nil {
+		return nil, ErrNoWorkerPool
+	}
+
+	job := &Job{Spec: spec, done: make(chan struct{})}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.stopped {
+		return nil, ErrClosed
+	}
+	if p.policy == QueueFail {
+		select {
+		case p.jobs <- job:
+			return job, nil
+		default:
+			return nil, ErrQueueFull
+		}
+	}
+	select {
+	case p.jobs <- job:
+		return job, nil
+	case <-p.stop:
+		return nil, ErrClosed
+	}
+}
+
+// stopWorkerPool stops the worker pool, if any.
+func (pm *Manager) stopWorkerPool() {
+	pm.mutex.Lock()
+	p := pm.workers
+	pm.workers = nil
+	pm.mutex.Unlock()
+	if p != nil {
+		p.close()
+	}
+}
+
+func (p *workerPool) work(pm *Manager) {
+	for {
+		select {
+		case job := <-p.jobs:
+			job.finish(pm.ExecWithOptions(job.Spec.Description, job.Spec.Name, job.Spec.Args, job.Spec.Options...))
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *workerPool) close() {
+	close(p.stop)
+	p.mutex.Lock()
+	p.stopped = true
+	p.mutex.Unlock()
+	for {
+		select {
+		case job := <-p.jobs:
+			job.finish("", "", ErrClosed)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_WithWorkerPool(t *testing.T) {
+	pm := newFakeManager()
+	defer pm.Close()
+
+	_, err := pm.Submit(ExecSpec{Description: "Echo", Name: "echo", Args: []string{"hello"}})
+	assert.Equal(t, ErrNoWorkerPool, err)
+
+	const workers, jobs = 8, 64
+	baseline := runtime.NumGoroutine()
+	pm.WithWorkerPool(workers, 8, QueueBlock)
+
+	maxGoroutines := 0
+	submitted := make([]*Job, 0, jobs)
+	for i := 0; i < jobs; i++ {
+		job, err := pm.Submit(ExecSpec{Description: "Echo", Name: "echo", Args: []string{"hello"}})
+		assert.NoError(t, err)
+		submitted = append(submitted, job)
+		if n := runtime.NumGoroutine(); n > maxGoroutines {
+			maxGoroutines = n
+		}
+	}
+	for _, job := range submitted {
+		stdout, _, err := job.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", stdout)
+	}
+	// Each running command needs a few goroutines of its own to copy its outputs
+	assert.True(t, maxGoroutines < baseline+workers*8, "%d goroutines for %d workers", maxGoroutines-baseline, workers)
+}
+
+func TestManager_WithWorkerPool_QueueFail(t *testing.T) {
+	pm := newFakeManager()
+	pm.WithWorkerPool(1, 1, QueueFail)
+
+	// The worker runs one job and the queue holds another one
+	var accepted []*Job
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		var job *Job
+		job, err = pm.Submit(ExecSpec{Description: "Hang", Name: "hang"})
+		if err == nil {
+			accepted = append(accepted, job)
+		}
+	}
+	assert.Equal(t, ErrQueueFull, err)
+
+	assert.NoError(t, pm.Close())
+	for _, job := range accepted {
+		select {
+		case <-job.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("a job was left pending once the manager was closed")
+		}
+		_, _, err := job.Wait()
+		assert.Error(t, err)
+	}
+	_, err = pm.Submit(ExecSpec{Description: "Echo", Name: "echo"})
+	assert.Equal(t, ErrClosed, err)
+}